@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	proxyproto "github.com/nabeken/go-proxyproto"
+)
+
+const inspectUsage = `usage: proxyproto inspect [-json] [-hex string | file]
+
+Inspect decodes a PROXY protocol header and prints its version, command,
+address family, addresses, ports and TLVs.
+
+The header is read from the given file, from the -hex flag or from stdin.
+Hex input may contain whitespace and colons. Lines of hex dumps of the
+payload, as printed by tcpdump -X or copied from Wireshark with "Copy as
+Hex Dump", can be pasted as is: their offset and ASCII columns are ignored.
+
+Flags:
+`
+
+// headerInfo is the decoded view of a header.
+type headerInfo struct {
+	Version            int       `json:"version"`
+	Command            string    `json:"command"`
+	Protocol           string    `json:"protocol"`
+	SourceAddress      string    `json:"source_address,omitempty"`
+	SourcePort         *uint16   `json:"source_port,omitempty"`
+	DestinationAddress string    `json:"destination_address,omitempty"`
+	DestinationPort    *uint16   `json:"destination_port,omitempty"`
+	TLVs               []tlvInfo `json:"tlvs,omitempty"`
+}
+
+// inspection is what inspect prints.
+type inspection struct {
+	headerInfo
+	HeaderLength  int `json:"header_length"`
+	PayloadLength int `json:"payload_length"`
+}
+
+type tlvInfo struct {
+	Type  byte   `json:"type"`
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value"`
+}
+
+func runInspect(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	hexInput := fs.String("hex", "", "decode the given hex string")
+	asJSON := fs.Bool("json", false, "print the header as JSON")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), inspectUsage)
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	data, err := readInspectInput(*hexInput, fs.Arg(0), stdin)
+	if err != nil {
+		return err
+	}
+
+	ins, err := inspect(data)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(ins)
+	}
+	return ins.writeText(stdout)
+}
+
+func readInspectInput(hexInput, filename string, stdin io.Reader) ([]byte, error) {
+	switch {
+	case hexInput != "" && filename != "":
+		return nil, errors.New("-hex and file are mutually exclusive")
+	case hexInput != "":
+		return decodeHex(hexInput)
+	case filename != "" && filename != "-":
+		return os.ReadFile(filename)
+	}
+	return io.ReadAll(stdin)
+}
+
+// decodeHex decodes s ignoring whitespace, colons and a leading 0x.
+func decodeHex(s string) ([]byte, error) {
+	var b strings.Builder
+	for _, line := range strings.Split(s, "\n") {
+		if m := hexDumpLine.FindStringSubmatch(line); m != nil {
+			b.WriteString(hexDumpBytes(m[1]))
+			continue
+		}
+		b.WriteString(line)
+	}
+
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == ':' {
+			return -1
+		}
+		return r
+	}, b.String())
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex input: %v", err)
+	}
+	return data, nil
+}
+
+// hexDumpLine matches a line of hex dump starting with an offset, as printed
+// by tcpdump -x or -X ("0x0010:  0d0a 0d0a ...") or by Wireshark's "Copy as
+// Hex Dump" ("0010   0d 0a 0d 0a ..."). The submatch is what follows the
+// offset.
+var hexDumpLine = regexp.MustCompile(`^\s*(?:0x[0-9a-fA-F]+:|[0-9a-fA-F]{4}\s\s)\s*(.*)$`)
+
+// hexDumpGroup matches a group of 2 or 4 hex digits of a line of hex dump
+// along with the whitespace following it.
+var hexDumpGroup = regexp.MustCompile(`^([0-9a-fA-F]{4}|[0-9a-fA-F]{2})(\s+|$)`)
+
+// hexDumpBytes returns the hex of a line of hex dump without its ASCII
+// column, i.e. up to 16 bytes of leading groups of 2 or 4 hex digits. The
+// ASCII column is separated from them by more than a space, even on the
+// short last line of a dump, except that Wireshark puts two spaces after the
+// 8th byte.
+func hexDumpBytes(s string) string {
+	var b strings.Builder
+	for {
+		m := hexDumpGroup.FindStringSubmatch(s)
+		if m == nil || b.Len()+len(m[1]) > 32 {
+			break
+		}
+		b.WriteString(m[1])
+		s = s[len(m[0]):]
+		if gap := m[2]; len(gap) > 1 && !(gap == "  " && len(m[1]) == 2 && b.Len() == 16) {
+			break
+		}
+	}
+	return b.String()
+}
+
+func inspect(data []byte) (*inspection, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty input")
+	}
+
+	r := bytes.NewReader(data)
+	br := bufio.NewReader(r)
+	hdr, err := proxyproto.Read(br)
+	if errors.Is(err, proxyproto.ErrNoProxyProtocol) {
+		return nil, fmt.Errorf("no PROXY protocol signature in %d bytes of input starting with %q", len(data), prefix(data, 16))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	headerLen := len(data) - r.Len() - br.Buffered()
+	ins := &inspection{
+		HeaderLength:  headerLen,
+		PayloadLength: len(data) - headerLen,
+	}
+
+	if hdr == nil {
+		// Read drops LOCAL headers since their addresses must be ignored
+		ins.Version = 2
+		ins.Command = proxyproto.ProtocolVersionAndCommand(data[12]).String()
+		ins.Protocol = proxyproto.AddressFamilyAndProtocol(data[13]).String()
+		return ins, nil
+	}
+
+	ins.headerInfo = *newHeaderInfo(hdr)
+	return ins, nil
+}
+
+func newHeaderInfo(hdr *proxyproto.Header) *headerInfo {
+	info := &headerInfo{
+		Version:  hdr.Version,
+		Command:  hdr.Command.String(),
+		Protocol: hdr.TransportProtocol.String(),
+	}
+	if hdr.Version == 1 {
+		// v1 has no command, every header is PROXY
+		info.Command = "PROXY"
+	}
+	if !hdr.TransportProtocol.IsUnspec() {
+		info.SourceAddress = hdr.SrcAddr.String()
+		info.SourcePort = &hdr.SrcPort
+		info.DestinationAddress = hdr.DstAddr.String()
+		info.DestinationPort = &hdr.DstPort
+	}
+	for _, tlv := range hdr.TLVs {
+		info.TLVs = append(info.TLVs, tlvInfo{
+			Type:  byte(tlv.Type),
+			Name:  tlvName(tlv.Type),
+			Value: hex.EncodeToString(tlv.Value),
+		})
+	}
+	return info
+}
+
+func (ins *inspection) writeText(w io.Writer) error {
+	buf := &bytes.Buffer{}
+	field := func(name, value string) {
+		fmt.Fprintf(buf, "%-13s %s\n", name+":", value)
+	}
+
+	field("version", strconv.Itoa(ins.Version))
+	field("command", ins.Command)
+	field("protocol", ins.Protocol)
+	if ins.SourcePort != nil {
+		field("source", net.JoinHostPort(ins.SourceAddress, strconv.Itoa(int(*ins.SourcePort))))
+		field("destination", net.JoinHostPort(ins.DestinationAddress, strconv.Itoa(int(*ins.DestinationPort))))
+	}
+	for _, tlv := range ins.TLVs {
+		name := tlv.Name
+		if name == "" {
+			name = "unknown"
+		}
+		value, _ := hex.DecodeString(tlv.Value)
+		desc := fmt.Sprintf("0x%02x %s, %d bytes", tlv.Type, name, len(value))
+		if len(value) > 0 {
+			desc += ": " + tlv.Value
+			if isPrintable(value) {
+				desc += fmt.Sprintf(" (%q)", value)
+			}
+		}
+		field("tlv", desc)
+	}
+	field("header", fmt.Sprintf("%d bytes", ins.HeaderLength))
+	field("payload", fmt.Sprintf("%d bytes", ins.PayloadLength))
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// tlvName returns the name of typ, or "" if typ is not a known type.
+func tlvName(typ proxyproto.PP2Type) string {
+	name := typ.String()
+	if strings.HasPrefix(name, "0x") {
+		return ""
+	}
+	return name
+}
+
+func isPrintable(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func prefix(b []byte, n int) []byte {
+	if len(b) > n {
+		return b[:n]
+	}
+	return b
+}
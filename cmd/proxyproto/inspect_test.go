@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	proxyproto "github.com/nabeken/go-proxyproto"
+)
+
+const (
+	// PROXY TCPv4 127.0.0.1:56324 -> 127.0.0.2:443 with ALPN "h2", followed by "ping"
+	v2HexDump = `
+0d0a 0d0a 000d 0a51 5549 540a 2111 0011
+7f00 0001 7f00 0002 dc04 01bb 0100 0268
+3270 696e 67`
+)
+
+func TestInspect_Text(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		args     []string
+		stdin    string
+		expected []string
+	}{
+		{
+			name:  "v1 from stdin",
+			stdin: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nGET / HTTP/1.1\r\n",
+			expected: []string{
+				"version:      1",
+				"command:      PROXY",
+				"protocol:     TCPv6",
+				"source:       [2001:db8::1]:56324",
+				"destination:  [2001:db8::2]:443",
+				"header:       46 bytes",
+				"payload:      16 bytes",
+			},
+		},
+		{
+			name: "v2 from hex",
+			args: []string{"-hex", v2HexDump},
+			expected: []string{
+				"version:      2",
+				"command:      PROXY",
+				"protocol:     TCPv4",
+				"source:       127.0.0.1:56324",
+				"destination:  127.0.0.2:443",
+				`tlv:          0x01 ALPN, 2 bytes: 6832 ("h2")`,
+				"header:       33 bytes",
+				"payload:      4 bytes",
+			},
+		},
+		{
+			name: "v2 from tcpdump -X",
+			args: []string{"-hex", `
+	0x0000:  0d0a 0d0a 000d 0a51 5549 540a 2111 0011  .......QUIT.!...
+	0x0010:  7f00 0001 7f00 0002 dc04 01bb 0100 0268  ...............h
+	0x0020:  3270 696e 67                             2ping`},
+			expected: []string{
+				"source:       127.0.0.1:56324",
+				`tlv:          0x01 ALPN, 2 bytes: 6832 ("h2")`,
+				"payload:      4 bytes",
+			},
+		},
+		{
+			name: "v2 from Wireshark",
+			args: []string{"-hex", `
+0000   0d 0a 0d 0a 00 0d 0a 51 55 49 54 0a 21 11 00 11   .......QUIT.!...
+0010   7f 00 00 01 7f 00 00 02 dc 04 01 bb 01 00 02 68   ...............h
+0020   32 70 69 6e 67                                    2ping`},
+			expected: []string{
+				"source:       127.0.0.1:56324",
+				`tlv:          0x01 ALPN, 2 bytes: 6832 ("h2")`,
+				"payload:      4 bytes",
+			},
+		},
+		{
+			name: "tcpdump -X with hex digits in the ASCII column",
+			args: []string{"-hex", `
+	0x0000:  0d0a 0d0a 000d 0a51 5549 540a 2111 0011  .......QUIT.!...
+	0x0010:  7f00 0001 7f00 0002 dc04 01bb 0100 0268  ...............h
+	0x0020:  3231                                     21`},
+			expected: []string{
+				`tlv:          0x01 ALPN, 2 bytes: 6832 ("h2")`,
+				"payload:      1 bytes",
+			},
+		},
+		{
+			name: "Wireshark with hex digits in the ASCII column",
+			args: []string{"-hex", `
+0000   0d 0a 0d 0a 00 0d 0a 51 55 49 54 0a 21 11 00 11   .......QUIT.!...
+0010   7f 00 00 01 7f 00 00 02 dc 04 01 bb 01 00 02 68   ...............h
+0020   32 31                                             21`},
+			expected: []string{
+				`tlv:          0x01 ALPN, 2 bytes: 6832 ("h2")`,
+				"payload:      1 bytes",
+			},
+		},
+		{
+			name: "Wireshark with a gap after the 8th byte",
+			args: []string{"-hex", `
+0000   0d 0a 0d 0a 00 0d 0a 51  55 49 54 0a 21 11 00 0c   .......Q UIT.!...
+0010   7f 00 00 01 7f 00 00 02  dc 04 01 bb               ........ ....`},
+			expected: []string{
+				"source:       127.0.0.1:56324",
+				"payload:      0 bytes",
+			},
+		},
+		{
+			name: "v2 LOCAL",
+			args: []string{"-hex", "0d0a0d0a000d0a515549540a20000000"},
+			expected: []string{
+				"version:      2",
+				"command:      LOCAL",
+				"protocol:     UNSPEC",
+				"header:       16 bytes",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout := &bytes.Buffer{}
+			if err := runInspect(tt.args, strings.NewReader(tt.stdin), stdout); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			for _, line := range tt.expected {
+				if !strings.Contains(stdout.String(), line+"\n") {
+					t.Errorf("expected %q in\n%s", line, stdout.String())
+				}
+			}
+		})
+	}
+}
+
+func TestInspect_JSON(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	if err := runInspect([]string{"-json", "-hex", v2HexDump}, nil, stdout); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	var actual inspection
+	if err := json.Unmarshal(stdout.Bytes(), &actual); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if actual.Version != 2 ||
+		actual.Protocol != "TCPv4" ||
+		actual.SourceAddress != "127.0.0.1" ||
+		actual.SourcePort == nil || *actual.SourcePort != 56324 ||
+		actual.DestinationAddress != "127.0.0.2" ||
+		actual.DestinationPort == nil || *actual.DestinationPort != 443 ||
+		len(actual.TLVs) != 1 || actual.TLVs[0].Name != "ALPN" || actual.TLVs[0].Value != "6832" {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+}
+
+func TestInspect_Invalid(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		args          []string
+		stdin         string
+		expectedError error
+		expectedText  string
+	}{
+		{
+			name:         "empty",
+			expectedText: "empty input",
+		},
+		{
+			name:         "invalid hex",
+			args:         []string{"-hex", "0d0g"},
+			expectedText: "invalid hex input",
+		},
+		{
+			name:          "no signature",
+			stdin:         "GET / HTTP/1.1\r\n",
+			expectedError: nil,
+			expectedText:  "no PROXY protocol signature",
+		},
+		{
+			name:          "truncated v2",
+			args:          []string{"-hex", v2HexDump[:40]},
+			expectedError: proxyproto.ErrInvalidLength,
+		},
+		{
+			name:          "invalid v1 address",
+			stdin:         "PROXY TCP4 2001:db8::1 2001:db8::2 56324 443\r\n",
+			expectedError: proxyproto.ErrInvalidAddress,
+			expectedText:  "source address at offset 11",
+		},
+		{
+			name:          "too many arguments",
+			args:          []string{"a", "b"},
+			expectedError: errUsage,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runInspect(tt.args, strings.NewReader(tt.stdin), &bytes.Buffer{})
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if tt.expectedError != nil && !errors.Is(err, tt.expectedError) {
+				t.Errorf("expected '%v', actual '%v'", tt.expectedError, err)
+			}
+			if !strings.Contains(err.Error(), tt.expectedText) {
+				t.Errorf("expected %q in '%v'", tt.expectedText, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Command proxyproto is a toolbox for debugging PROXY protocol deployments.
+//
+// Usage:
+//
+//	proxyproto <command> [flags] [args]
+//
+// The commands are:
+//
+//	inspect    decode a PROXY protocol header from stdin, a file or a hex string
+//	relay      forward TCP connections to a backend with a PROXY protocol header
+//	serve      accept PROXY protocol connections and echo the header and payload back
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// errUsage is returned by commands given invalid flags or arguments.
+// The problem has already been reported by the time it is returned.
+var errUsage = errors.New("invalid usage")
+
+type command struct {
+	name  string
+	short string
+	run   func(args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+var commands = []command{
+	{
+		name:  "inspect",
+		short: "decode a PROXY protocol header from stdin, a file or a hex string",
+		run:   runInspect,
+	},
+	{
+		name:  "relay",
+		short: "forward TCP connections to a backend with a PROXY protocol header",
+		run:   runRelay,
+	},
+	{
+		name:  "serve",
+		short: "accept PROXY protocol connections and echo the header and payload back",
+		run:   runServe,
+	},
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: proxyproto <command> [flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "The commands are:")
+	fmt.Fprintln(w)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "\t%-10s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Use "proxyproto <command> -h" for more information about a command.`)
+}
+
+// parseFlags parses args and makes sure no more than maxArgs positional arguments remain.
+func parseFlags(fs *flag.FlagSet, args []string, maxArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > maxArgs {
+		fmt.Fprintf(fs.Output(), "too many arguments: %q\n", fs.Args()[maxArgs:])
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	if name == "-h" || name == "-help" || name == "--help" || name == "help" {
+		usage(os.Stdout)
+		return
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			if err == errUsage {
+				os.Exit(2)
+			}
+			fmt.Fprintf(os.Stderr, "proxyproto %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "proxyproto: unknown command %q\n\n", name)
+	usage(os.Stderr)
+	os.Exit(2)
+}
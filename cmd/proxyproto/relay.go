@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	proxyproto "github.com/nabeken/go-proxyproto"
+)
+
+const relayUsage = `usage: proxyproto relay -listen addr -backend addr [-version 1|2] [-tlv type=hex ...]
+
+Relay accepts TCP connections and forwards each of them to the backend after
+writing a PROXY protocol header describing the client connection, acting as a
+local stand-in for a PROXY protocol speaking load balancer.
+
+Flags:
+`
+
+// tlvFlag collects static TLVs given as type=hex, where type is either
+// a number (e.g. 0xEA) or a name (e.g. ALPN).
+type tlvFlag []proxyproto.TLV
+
+func (f *tlvFlag) String() string {
+	var s []string
+	for _, tlv := range *f {
+		s = append(s, fmt.Sprintf("0x%02x=%x", byte(tlv.Type), tlv.Value))
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *tlvFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return errors.New("must be type=hex")
+	}
+	typ, err := parseTLVType(s[:i])
+	if err != nil {
+		return err
+	}
+	value, err := decodeHex(s[i+1:])
+	if err != nil {
+		return err
+	}
+	*f = append(*f, proxyproto.TLV{Type: typ, Value: value})
+	return nil
+}
+
+func parseTLVType(s string) (proxyproto.PP2Type, error) {
+	for typ := 0; typ <= 0xff; typ++ {
+		if name := tlvName(proxyproto.PP2Type(typ)); name != "" && strings.EqualFold(name, s) {
+			return proxyproto.PP2Type(typ), nil
+		}
+	}
+	typ, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid TLV type %q", s)
+	}
+	return proxyproto.PP2Type(typ), nil
+}
+
+func runRelay(args []string, stdin io.Reader, stdout io.Writer) error {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	r := &proxyproto.Relay{
+		ErrorLog: logger,
+	}
+	dialer := &net.Dialer{}
+
+	fs := flag.NewFlagSet("relay", flag.ContinueOnError)
+	listen := fs.String("listen", "", "address to listen on (required)")
+	fs.StringVar(&r.Backend, "backend", "", "address of the backend to forward connections to (required)")
+	fs.IntVar(&r.Version, "version", 1, "PROXY protocol version to send, 1 or 2")
+	fs.Var((*tlvFlag)(&r.TLVs), "tlv", "static TLV to send as type=hex, may be repeated (version 2 only)")
+	fs.DurationVar(&dialer.Timeout, "dial-timeout", 10*time.Second, "timeout for connecting to the backend")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), relayUsage)
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	switch {
+	case *listen == "" || r.Backend == "":
+		fmt.Fprintln(fs.Output(), "-listen and -backend are required")
+		fs.Usage()
+		return errUsage
+	case r.Version != 1 && r.Version != 2:
+		return fmt.Errorf("unsupported PROXY protocol version %d", r.Version)
+	case r.Version == 1 && len(r.TLVs) > 0:
+		return errors.New("-tlv requires -version 2")
+	}
+	r.Dial = dialer.Dial
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	logger.Printf("relaying %s to %s with PROXY protocol version %d", ln.Addr(), r.Backend, r.Version)
+	err = r.Serve(ln)
+	if ctx.Err() != nil {
+		// interrupted
+		return nil
+	}
+	return err
+}
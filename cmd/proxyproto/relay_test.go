@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"testing"
+
+	proxyproto "github.com/nabeken/go-proxyproto"
+)
+
+func TestRelay(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		version int
+		tlvs    []proxyproto.TLV
+	}{
+		{
+			name:    "v1",
+			version: 1,
+		},
+		{
+			name:    "v2 with TLVs",
+			version: 2,
+			tlvs:    []proxyproto.TLV{{Type: 0xEA, Value: []byte{0x01, 'v', 'p', 'c'}}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			backendLn, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			defer backendLn.Close()
+			backend := &proxyproto.Listener{Listener: backendLn}
+
+			relayLn, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			defer relayLn.Close()
+
+			r := &proxyproto.Relay{
+				Backend:  backendLn.Addr().String(),
+				Version:  tt.version,
+				TLVs:     tt.tlvs,
+				ErrorLog: log.New(io.Discard, "", 0),
+			}
+			go r.Serve(relayLn)
+
+			client, err := net.Dial("tcp", relayLn.Addr().String())
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			defer client.Close()
+			client.Write([]byte("ping"))
+			client.(*net.TCPConn).CloseWrite()
+
+			conn, err := backend.Accept()
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			defer conn.Close()
+
+			recv, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if string(recv) != "ping" {
+				t.Errorf("expected 'ping', got %q", recv)
+			}
+			if conn.RemoteAddr().String() != client.LocalAddr().String() {
+				t.Errorf("expected '%s', got '%s'", client.LocalAddr(), conn.RemoteAddr())
+			}
+			if conn.LocalAddr().String() != client.RemoteAddr().String() {
+				t.Errorf("expected '%s', got '%s'", client.RemoteAddr(), conn.LocalAddr())
+			}
+
+			// the client must still be able to read after the half-close
+			conn.Write([]byte("pong"))
+			conn.Close()
+			recv, err = io.ReadAll(client)
+			if err != nil && err != io.EOF {
+				t.Fatal("unexpected error:", err)
+			}
+			if !bytes.Equal(recv, []byte("pong")) {
+				t.Errorf("expected 'pong', got %q", recv)
+			}
+		})
+	}
+}
+
+func TestTLVFlag(t *testing.T) {
+	var f tlvFlag
+	for _, s := range []string{"alpn=6832", "0xEA=01766663", "224=00"} {
+		if err := f.Set(s); err != nil {
+			t.Fatalf("%s: unexpected error: %v", s, err)
+		}
+	}
+	if expected := "0x01=6832,0xea=01766663,0xe0=00"; f.String() != expected {
+		t.Errorf("expected '%s', got '%s'", expected, f.String())
+	}
+
+	for _, s := range []string{"alpn", "unknown=00", "0x100=00", "alpn=zz"} {
+		if err := f.Set(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
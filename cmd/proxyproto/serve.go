@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	proxyproto "github.com/nabeken/go-proxyproto"
+)
+
+const serveUsage = `usage: proxyproto serve -listen addr [-timeout duration]
+
+Serve accepts connections which may be speaking the PROXY protocol. For each
+connection, it writes a line of JSON describing the received header, the
+resulting addresses and the addresses of the socket peer (i.e. the load
+balancer), then echoes back whatever the client sends.
+
+This verifies from a shell what a load balancer really sends, e.g.:
+
+	$ echo ping | nc lb.example.com 8080
+
+Flags:
+`
+
+// connInfo is the JSON line written back to each client.
+type connInfo struct {
+	RemoteAddr     string      `json:"remote_addr"`
+	LocalAddr      string      `json:"local_addr"`
+	PeerRemoteAddr string      `json:"peer_remote_addr"`
+	PeerLocalAddr  string      `json:"peer_local_addr"`
+	Header         *headerInfo `json:"header"`
+	Error          string      `json:"error,omitempty"`
+}
+
+func runServe(args []string, stdin io.Reader, stdout io.Writer) error {
+	s := &server{
+		logger: log.New(os.Stderr, "", log.LstdFlags),
+	}
+
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := fs.String("listen", "", "address to listen on (required)")
+	fs.DurationVar(&s.timeout, "timeout", 10*time.Second, "maximum time to receive the PROXY protocol header, zero means no timeout")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), serveUsage)
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if *listen == "" {
+		fmt.Fprintln(fs.Output(), "-listen is required")
+		fs.Usage()
+		return errUsage
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	s.logger.Printf("listening on %s", ln.Addr())
+	err = s.serve(ctx, ln)
+	if ctx.Err() != nil {
+		// interrupted
+		return nil
+	}
+	return err
+}
+
+// server echoes the PROXY protocol header and the payload back to clients.
+type server struct {
+	timeout time.Duration
+	logger  *log.Logger
+}
+
+// serve accepts connections until ln is closed. Cancelling ctx interrupts
+// connections still waiting for the header.
+func (s *server) serve(ctx context.Context, ln net.Listener) error {
+	pl := &proxyproto.Listener{
+		Listener:           ln,
+		ProxyHeaderTimeout: s.timeout,
+	}
+	for {
+		conn, err := pl.AcceptContext(ctx)
+		if err != nil {
+			return err
+		}
+		go s.handle(conn.(*proxyproto.Conn))
+	}
+}
+
+func (s *server) handle(conn *proxyproto.Conn) {
+	defer conn.Close()
+
+	info := &connInfo{
+		PeerRemoteAddr: conn.NetConn().RemoteAddr().String(),
+		PeerLocalAddr:  conn.NetConn().LocalAddr().String(),
+	}
+	hdr, err := conn.ProxyHeader()
+	if err != nil {
+		info.Error = err.Error()
+	} else if hdr != nil {
+		info.Header = newHeaderInfo(hdr)
+	}
+	info.RemoteAddr = conn.RemoteAddr().String()
+	info.LocalAddr = conn.LocalAddr().String()
+
+	line, _ := json.Marshal(info)
+	s.logger.Printf("%s", line)
+	if err != nil {
+		// the connection has been closed
+		return
+	}
+
+	if _, err := conn.Write(append(line, '\n')); err != nil {
+		s.logger.Printf("%s: %v", info.RemoteAddr, err)
+		return
+	}
+	io.Copy(conn, conn)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"testing"
+
+	proxyproto "github.com/nabeken/go-proxyproto"
+)
+
+func TestServe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer ln.Close()
+
+	s := &server{
+		logger: log.New(io.Discard, "", 0),
+	}
+	go s.serve(context.Background(), ln)
+
+	for _, tt := range []struct {
+		name           string
+		header         *proxyproto.Header
+		expectedRemote string
+	}{
+		{
+			name: "v2",
+			header: &proxyproto.Header{
+				Version:           2,
+				Command:           proxyproto.PROXY,
+				TransportProtocol: proxyproto.TCPv4,
+				SrcAddr:           net.ParseIP("192.0.2.1"),
+				DstAddr:           net.ParseIP("192.0.2.2"),
+				SrcPort:           56324,
+				DstPort:           443,
+				TLVs:              []proxyproto.TLV{{Type: proxyproto.PP2_TYPE_ALPN, Value: []byte("h2")}},
+			},
+			expectedRemote: "192.0.2.1:56324",
+		},
+		{
+			name: "passthrough",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			defer conn.Close()
+
+			if tt.header != nil {
+				if _, err := tt.header.WriteTo(conn); err != nil {
+					t.Fatal("unexpected error:", err)
+				}
+			}
+			if _, err := conn.Write([]byte("ping\n")); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			br := bufio.NewReader(conn)
+			line, err := br.ReadBytes('\n')
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			var info connInfo
+			if err := json.Unmarshal(line, &info); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			expectedRemote := tt.expectedRemote
+			if expectedRemote == "" {
+				expectedRemote = conn.LocalAddr().String()
+			}
+			if info.RemoteAddr != expectedRemote {
+				t.Errorf("expected '%s', got '%s'", expectedRemote, info.RemoteAddr)
+			}
+			if info.PeerRemoteAddr != conn.LocalAddr().String() {
+				t.Errorf("expected '%s', got '%s'", conn.LocalAddr(), info.PeerRemoteAddr)
+			}
+			switch {
+			case tt.header == nil && info.Header != nil:
+				t.Errorf("expected no header, got %s", line)
+			case tt.header != nil && (info.Header == nil || info.Header.Version != 2 || len(info.Header.TLVs) != 1):
+				t.Errorf("unexpected header: %s", line)
+			}
+
+			echo, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if echo != "ping\n" {
+				t.Errorf("expected 'ping', got %q", echo)
+			}
+		})
+	}
+}
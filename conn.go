@@ -7,26 +7,45 @@ package proxyproto
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"io"
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	ErrInvalidUpstream = errors.New("proxyproto: upstream connection address not trusted for PROXY information")
+	ErrHeaderTimeout   = errors.New("proxyproto: timed out waiting for PROXY header")
 )
 
+// aLongTimeAgo is a non-zero time in the past used to interrupt blocking I/O immediately.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// headerBufferSize is the size of buffers used to read the header. It holds v1
+// headers and typical v2 ones while larger v2 headers are read past it.
+const headerBufferSize = 4096
+
+// headerReaderPool pools readers which are used only while reading the header
+// so that idle connections don't hold a buffer.
+var headerReaderPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewReaderSize(nil, headerBufferSize)
+	},
+}
+
 // SourceChecker can be used to decide whether to trust the PROXY info or pass
 // the original connection address through. If set, the connecting address is
 // passed in as an argument. If the function returns an error due to the source
 // being disallowed, it should return ErrInvalidUpstream.
 //
-// If error is not nil, the call to Accept() will fail. If the reason for
-// triggering this failure is due to a disallowed source, it should return
-// ErrInvalidUpstream.
+// If error is not nil, the connection is rejected: it is closed, counted in
+// Listener.Rejected and passed to Listener.OnReject, and Accept() waits for the
+// next connection. If the reason for triggering this failure is due to a
+// disallowed source, it should return ErrInvalidUpstream.
 //
 // If bool is true, the PROXY-set address is used.
 //
@@ -34,6 +53,20 @@ var (
 // address claimed in the PROXY info.
 type SourceChecker func(net.Addr) (bool, error)
 
+// HeaderTimeoutPolicy decides what happens to a connection when reading the header
+// times out (e.g. ProxyHeaderTimeout expires) before the header starts to arrive.
+type HeaderTimeoutPolicy int
+
+const (
+	// TimeoutPassthrough handles the connection as not using the proxy protocol,
+	// so that the connection's addresses are used. This is the default.
+	TimeoutPassthrough HeaderTimeoutPolicy = iota
+
+	// TimeoutReject fails the connection with ErrHeaderTimeout. Use it for listeners
+	// which must only accept proxied connections.
+	TimeoutReject
+)
+
 // Listener is used to wrap an underlying listener,
 // whose connections may be using the HAProxy Proxy Protocol (version 1).
 // If the connection is using the protocol, the RemoteAddr() will return
@@ -41,50 +74,222 @@ type SourceChecker func(net.Addr) (bool, error)
 //
 // Optionally define ProxyHeaderTimeout to set a maximum time to
 // receive the Proxy Protocol Header. Zero means no timeout.
+// HeaderTimeoutPolicy decides what happens when it expires.
+//
+// Optionally define MaxHeaderSize to limit the size in bytes of version 2
+// headers, which may be up to 65551 bytes long with TLVs. Larger headers fail
+// the connection with ErrHeaderTooLarge. Zero means no limit. It doesn't
+// apply to version 1 headers, which are always limited to the 107 bytes of
+// the specification.
+//
+// Optionally define OnReject to be notified of connections rejected by
+// SourceCheck. Rejections never fail Accept, so that servers such as
+// http.Server keep running.
+//
+// Optionally define Observer to collect metrics about the connections.
 type Listener struct {
-	Listener           net.Listener
-	ProxyHeaderTimeout time.Duration
-	SourceCheck        SourceChecker
+	Listener            net.Listener
+	ProxyHeaderTimeout  time.Duration
+	HeaderTimeoutPolicy HeaderTimeoutPolicy
+	SourceCheck         SourceChecker
+	MaxHeaderSize       int
+	OnReject            func(conn net.Conn, err error)
+	Observer            Observer
+
+	rejected atomic.Uint64
+
+	// Accepts of the underlying listener run in the background while a context
+	// can interrupt waiting for them. running counts those not handed over yet
+	// and waiting the callers waiting for them.
+	mu       sync.Mutex
+	accepted chan acceptResult
+	done     chan struct{}
+	running  int
+	waiting  int
 }
 
 // Conn is used to wrap and underlying connection which
 // may be speaking the Proxy Protocol. If it is, the RemoteAddr() will
 // return the address of the client instead of the proxy address.
 type Conn struct {
-	br   *bufio.Reader
 	conn net.Conn
 
-	header *Header
+	// rest holds bytes read ahead while reading the header.
+	// They are returned by Read before reading from conn.
+	rest []byte
+
+	header    *Header
+	headerErr error
 
-	useConnAddr        bool
-	once               sync.Once
-	proxyHeaderTimeout time.Duration
+	useConnAddr         bool
+	once                sync.Once
+	proxyHeaderTimeout  time.Duration
+	headerTimeoutPolicy HeaderTimeoutPolicy
+	maxHeaderSize       int
+	observer            Observer
+
+	// ctx is bound by Listener.AcceptContext and interrupts reading the header.
+	ctx context.Context
+
+	// readDeadline is the read deadline set by the user.
+	// It is restored after the header is read with ProxyHeaderTimeout.
+	mu           sync.Mutex
+	readDeadline time.Time
 }
 
 // Accept waits for and returns the next connection to the listener.
 func (p *Listener) Accept() (net.Conn, error) {
-	// Get the underlying connection
-	conn, err := p.Listener.Accept()
+	conn, err := p.acceptContext(context.Background())
 	if err != nil {
 		return nil, err
 	}
-	var useConnAddr bool
-	if p.SourceCheck != nil {
-		allowed, err := p.SourceCheck(conn.RemoteAddr())
+	return conn, nil
+}
+
+// AcceptContext waits for and returns the next connection to the listener like Accept.
+// ctx is bound to the returned connection: if ctx is done while its header is being
+// read, the read is interrupted, the connection is closed and ctx.Err() is returned.
+// This allows graceful shutdown not to hang on clients which never send the header.
+//
+// ctx also interrupts waiting for a connection. The Accept of the underlying listener
+// keeps running in the background then, and its connection is returned by the next
+// call to Accept or AcceptContext, so that no connection is lost.
+func (p *Listener) AcceptContext(ctx context.Context) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	conn, err := p.acceptContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn.ctx = ctx
+	return conn, nil
+}
+
+// acceptResult is the result of an Accept running in the background.
+type acceptResult struct {
+	conn *Conn
+	err  error
+}
+
+// acceptContext accepts a connection in the background so that ctx can interrupt
+// waiting for it. It accepts directly when nothing can interrupt it.
+func (p *Listener) acceptContext(ctx context.Context) (*Conn, error) {
+	p.mu.Lock()
+	if ctx.Done() == nil && p.running == 0 {
+		p.mu.Unlock()
+		return p.accept()
+	}
+	if p.accepted == nil {
+		p.accepted = make(chan acceptResult)
+		p.done = make(chan struct{})
+	}
+	accepted, done := p.accepted, p.done
+	p.waiting++
+	if p.running < p.waiting {
+		p.running++
+		go p.acceptBackground(accepted, done)
+	}
+	p.mu.Unlock()
+
+	var err error
+	select {
+	case r := <-accepted:
+		p.mu.Lock()
+		p.running--
+		p.waiting--
+		p.mu.Unlock()
+		return r.conn, r.err
+	case <-done:
+		err = net.ErrClosed
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	p.mu.Lock()
+	p.waiting--
+	p.mu.Unlock()
+	return nil, err
+}
+
+// acceptBackground hands the next connection to whoever waits for it first.
+// It is closed if the listener is closed before.
+func (p *Listener) acceptBackground(accepted chan<- acceptResult, done <-chan struct{}) {
+	conn, err := p.accept()
+	select {
+	case accepted <- acceptResult{conn, err}:
+	case <-done:
+		if conn != nil {
+			conn.Close()
+		}
+		p.mu.Lock()
+		p.running--
+		p.mu.Unlock()
+	}
+}
+
+func (p *Listener) accept() (*Conn, error) {
+	for {
+		// Get the underlying connection
+		conn, err := p.Listener.Accept()
 		if err != nil {
 			return nil, err
 		}
-		if !allowed {
-			useConnAddr = true
+		var useConnAddr bool
+		if p.SourceCheck != nil {
+			allowed, err := p.SourceCheck(conn.RemoteAddr())
+			if err != nil {
+				p.reject(conn, err)
+				continue
+			}
+			if !allowed {
+				useConnAddr = true
+			}
 		}
+		return p.newConn(conn, useConnAddr), nil
 	}
+}
+
+// reject closes conn rejected by SourceCheck with err.
+func (p *Listener) reject(conn net.Conn, err error) {
+	conn.Close()
+	p.rejected.Add(1)
+	if p.Observer != nil {
+		p.Observer.Rejected(conn, err)
+	}
+	if p.OnReject != nil {
+		p.OnReject(conn, err)
+	}
+}
+
+// Rejected returns the number of connections rejected by SourceCheck so far.
+func (p *Listener) Rejected() uint64 {
+	return p.rejected.Load()
+}
+
+func (p *Listener) newConn(conn net.Conn, useConnAddr bool) *Conn {
 	newConn := NewConn(conn, p.ProxyHeaderTimeout)
 	newConn.useConnAddr = useConnAddr
-	return newConn, nil
+	newConn.headerTimeoutPolicy = p.HeaderTimeoutPolicy
+	newConn.maxHeaderSize = p.MaxHeaderSize
+	newConn.observer = p.Observer
+	if p.Observer != nil {
+		p.Observer.Accepted(conn)
+	}
+	return newConn
 }
 
-// Close closes the underlying listener.
+// Close closes the underlying listener. A connection accepted in the background
+// for AcceptContext and not returned yet is closed.
 func (p *Listener) Close() error {
+	p.mu.Lock()
+	if p.done != nil {
+		select {
+		case <-p.done:
+		default:
+			close(p.done)
+		}
+	}
+	p.mu.Unlock()
 	return p.Listener.Close()
 }
 
@@ -97,7 +302,6 @@ func (p *Listener) Addr() net.Addr {
 // the proxy protocol into a proxyproto.Conn
 func NewConn(conn net.Conn, timeout time.Duration) *Conn {
 	pConn := &Conn{
-		br:                 bufio.NewReader(conn),
 		conn:               conn,
 		proxyHeaderTimeout: timeout,
 	}
@@ -106,27 +310,95 @@ func NewConn(conn net.Conn, timeout time.Duration) *Conn {
 
 // Read is check for the proxy protocol header when doing
 // the initial scan. If there is an error parsing the header,
-// it is returned and the socket is closed.
+// it is returned and the socket is closed. The error is sticky:
+// every later call returns it too.
 func (p *Conn) Read(b []byte) (int, error) {
-	var err error
-	p.once.Do(func() { err = p.readHeader() })
-	if err != nil {
+	if err := p.readHeaderOnce(p.context()); err != nil {
+		return 0, err
+	}
+	if len(p.rest) > 0 {
+		n := copy(b, p.rest)
+		p.rest = p.rest[n:]
+		if len(p.rest) == 0 {
+			p.rest = nil
+		}
+		return n, nil
+	}
+	return p.conn.Read(b)
+}
+
+// WriteTo implements io.WriterTo. After the header is consumed, the bytes already
+// buffered are written to w first, then the rest is copied from the underlying
+// connection so that io.Copy can use zero-copy paths such as splice(2) and sendfile(2).
+func (p *Conn) WriteTo(w io.Writer) (int64, error) {
+	if err := p.readHeaderOnce(p.context()); err != nil {
 		return 0, err
 	}
-	return p.br.Read(b)
+
+	var n int64
+	if len(p.rest) > 0 {
+		nn, err := w.Write(p.rest)
+		n = int64(nn)
+		p.rest = p.rest[nn:]
+		if err != nil {
+			return n, err
+		}
+		p.rest = nil
+	}
+	m, err := io.Copy(w, p.conn)
+	return n + m, err
 }
 
 func (p *Conn) Write(b []byte) (int, error) {
 	return p.conn.Write(b)
 }
 
+// ReadFrom implements io.ReaderFrom. If the underlying connection implements
+// io.ReaderFrom (e.g. *net.TCPConn), it is used directly.
+func (p *Conn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := p.conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(p.conn, r)
+}
+
 func (p *Conn) Close() error {
 	return p.conn.Close()
 }
 
+// CloseWrite shuts down the writing side of the underlying connection if it supports
+// half-close (e.g. *net.TCPConn), otherwise it returns errors.ErrUnsupported.
+func (p *Conn) CloseWrite() error {
+	if c, ok := p.conn.(interface{ CloseWrite() error }); ok {
+		return c.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+// CloseRead shuts down the reading side of the underlying connection if it supports
+// half-close (e.g. *net.TCPConn), otherwise it returns errors.ErrUnsupported.
+func (p *Conn) CloseRead() error {
+	if c, ok := p.conn.(interface{ CloseRead() error }); ok {
+		return c.CloseRead()
+	}
+	return errors.ErrUnsupported
+}
+
+// NetConn returns the underlying connection, e.g. to access *net.TCPConn specific methods.
+// Reading from it directly skips the proxy protocol header handling and bytes already
+// buffered by p.
+func (p *Conn) NetConn() net.Conn {
+	return p.conn
+}
+
+// Unwrap is an alias for NetConn.
+func (p *Conn) Unwrap() net.Conn {
+	return p.conn
+}
+
 func (p *Conn) LocalAddr() net.Addr {
-	p.readHeaderOnce()
-	if p.header == nil || p.useConnAddr {
+	p.readHeaderOnce(p.context())
+	if !p.useHeaderAddr() {
 		return p.conn.LocalAddr()
 	}
 	return p.header.LocalAddr()
@@ -135,23 +407,59 @@ func (p *Conn) LocalAddr() net.Addr {
 // RemoteAddr returns the address of the client if the proxy
 // protocol is being used, otherwise just returns the address of
 // the socket peer. If there is an error parsing the header, the
-// address of the client is not returned, and the socket is closed.
-// Once implication of this is that the call could block if the
+// address of the socket peer is returned from then on, and the socket
+// is closed. Once implication of this is that the call could block if the
 // client is slow. Using a Deadline is recommended if this is called
 // before Read()
 func (p *Conn) RemoteAddr() net.Addr {
-	p.readHeaderOnce()
-	if p.header == nil || p.useConnAddr {
+	p.readHeaderOnce(p.context())
+	if !p.useHeaderAddr() {
 		return p.conn.RemoteAddr()
 	}
 	return p.header.RemoteAddr()
 }
 
+// useHeaderAddr returns true if the addresses in the header should be used in place of
+// the connection's ones. UNSPEC headers (e.g. "PROXY UNKNOWN") carry no usable address.
+func (p *Conn) useHeaderAddr() bool {
+	return p.header != nil && !p.useConnAddr && !p.header.TransportProtocol.IsUnspec()
+}
+
+// ReadHeaderContext reads the proxy protocol header if it has not been read yet and
+// returns the error raised while reading it. If ctx is done before the header is read,
+// the read is interrupted, the connection is closed and ctx.Err() is returned.
+func (p *Conn) ReadHeaderContext(ctx context.Context) error {
+	return p.readHeaderOnce(ctx)
+}
+
+// ProxyHeader returns the proxy protocol header received on the connection,
+// reading it first if needed. The header is nil if the connection doesn't use
+// the proxy protocol, the command is LOCAL or the upstream is not trusted by
+// SourceCheck. If there is an error parsing the header, it is returned and
+// the socket is closed.
+func (p *Conn) ProxyHeader() (*Header, error) {
+	if err := p.readHeaderOnce(p.context()); err != nil {
+		return nil, err
+	}
+	if p.useConnAddr {
+		return nil, nil
+	}
+	return p.header, nil
+}
+
 func (p *Conn) SetDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readDeadline = t
 	return p.conn.SetDeadline(t)
 }
 
+// SetReadDeadline sets the read deadline. While reading the header, the tighter of
+// the deadline and ProxyHeaderTimeout applies and the deadline is restored afterwards.
 func (p *Conn) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readDeadline = t
 	return p.conn.SetReadDeadline(t)
 }
 
@@ -159,29 +467,140 @@ func (p *Conn) SetWriteDeadline(t time.Time) error {
 	return p.conn.SetWriteDeadline(t)
 }
 
-func (p *Conn) readHeaderOnce() {
+// readHeaderOnce reads the header on the first call. A failure is sticky: the
+// connection is closed and the same error is returned to every caller, so that
+// nothing reads past a half-parsed header.
+func (p *Conn) readHeaderOnce(ctx context.Context) error {
 	p.once.Do(func() {
-		if err := p.readHeader(); err != nil && err != io.EOF {
-			log.Printf("[ERR] Failed to read proxy prefix: %v", err)
-			p.Close()
-			p.br = bufio.NewReader(p.conn)
+		start := time.Now()
+		outcome, err := p.readHeader(ctx)
+		p.headerErr = err
+		if p.observer != nil {
+			p.observe(outcome, time.Since(start))
+		}
+		if p.headerErr != nil {
+			// a cancellation is expected, e.g. on graceful shutdown
+			if p.headerErr != io.EOF && outcome != headerCancelled {
+				log.Printf("[ERR] Failed to read proxy prefix: %v", p.headerErr)
+			}
+			p.conn.Close()
 		}
 	})
+	return p.headerErr
+}
+
+// setHeaderReadDeadline applies the tighter of the user's read deadline and ProxyHeaderTimeout.
+func (p *Conn) setHeaderReadDeadline() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	deadline := time.Now().Add(p.proxyHeaderTimeout)
+	if !p.readDeadline.IsZero() && p.readDeadline.Before(deadline) {
+		deadline = p.readDeadline
+	}
+	p.conn.SetReadDeadline(deadline)
+}
+
+// restoreReadDeadline restores the user's read deadline after reading the header.
+func (p *Conn) restoreReadDeadline() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conn.SetReadDeadline(p.readDeadline)
 }
 
-func (p *Conn) readHeader() error {
+func (p *Conn) context() context.Context {
+	if p.ctx != nil {
+		return p.ctx
+	}
+	return context.Background()
+}
+
+// headerOutcome tells how readHeader went. It comes along with the error
+// readHeader returns, if any: e.g. headerFound with a parse error, or
+// headerTimedOut with ErrHeaderTimeout.
+type headerOutcome int
+
+const (
+	headerFound headerOutcome = iota
+	headerMissing
+	headerTimedOut
+	headerCancelled
+)
+
+func (p *Conn) readHeader(ctx context.Context) (outcome headerOutcome, err error) {
 	if p.proxyHeaderTimeout != 0 {
-		readDeadLine := time.Now().Add(p.proxyHeaderTimeout)
-		p.conn.SetReadDeadline(readDeadLine)
-		defer p.conn.SetReadDeadline(time.Time{})
+		p.setHeaderReadDeadline()
+		defer p.restoreReadDeadline()
 	}
 
-	var err error
-	p.header, err = Read(p.br)
-	if err != nil && err != ErrNoProxyProtocol {
+	if ctx.Done() != nil {
+		if err := ctx.Err(); err != nil {
+			p.conn.Close()
+			return headerCancelled, err
+		}
+		// interrupt the blocking Read when ctx is done
+		interrupted := make(chan struct{})
+		stop := context.AfterFunc(ctx, func() {
+			p.conn.SetReadDeadline(aLongTimeAgo)
+			close(interrupted)
+		})
+		defer func() {
+			if !stop() {
+				<-interrupted
+				p.conn.Close()
+				outcome, err = headerCancelled, ctx.Err()
+			}
+		}()
+	}
+
+	br := headerReaderPool.Get().(*bufio.Reader)
+	br.Reset(p.conn)
+	defer func() {
+		// hand back bytes read ahead of the header
+		if n := br.Buffered(); n > 0 {
+			b, _ := br.Peek(n)
+			p.rest = append([]byte(nil), b...)
+		}
+		br.Reset(nil)
+		headerReaderPool.Put(br)
+	}()
+
+	p.header, err = read(br, p.maxHeaderSize)
+	if errors.Is(err, ErrNoProxyProtocol) {
+		if isTimeout(err) {
+			if p.headerTimeoutPolicy == TimeoutReject {
+				p.conn.Close()
+				return headerTimedOut, ErrHeaderTimeout
+			}
+			return headerTimedOut, nil
+		}
 		// if there is not proxy protocol signature, the further R/W operation just works.
-		return err
+		return headerMissing, nil
+	}
+	return headerFound, err
+}
+
+// observe reports the outcome of reading the header to the observer.
+// Cancellations, e.g. on graceful shutdown, are not reported.
+func (p *Conn) observe(outcome headerOutcome, elapsed time.Duration) {
+	switch {
+	case outcome == headerCancelled:
+	case p.headerErr == ErrHeaderTimeout:
+		p.observer.HeaderTimeout(p.conn, elapsed)
+	case p.headerErr != nil:
+		p.observer.ParseError(p.conn, p.headerErr, elapsed)
+	case outcome == headerTimedOut:
+		p.observer.HeaderTimeout(p.conn, elapsed)
+	case outcome == headerMissing:
+		p.observer.NoHeader(p.conn, elapsed)
+	case p.header == nil:
+		// Read drops LOCAL headers since their addresses must be ignored
+		p.observer.HeaderParsed(p.conn, &Header{Version: 2, Command: LOCAL}, elapsed)
+	default:
+		p.observer.HeaderParsed(p.conn, p.header, elapsed)
 	}
+}
 
-	return nil
+func isTimeout(err error) bool {
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
 }
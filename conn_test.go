@@ -2,7 +2,9 @@ package proxyproto
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net"
@@ -201,6 +203,27 @@ func TestConn_ProxyProtoV1(t *testing.T) {
 	s.WaitConnClosed(conn)
 }
 
+func TestConn_ProxyProtoV1_UNKNOWN(t *testing.T) {
+	s := NewTestServer(t, 0)
+
+	go func() {
+		conn := s.MustClientConn()
+		defer conn.Close()
+		conn.Write([]byte("PROXY UNKNOWN\r\n"))
+		s.AssertClientReadWrite(conn)
+	}()
+
+	conn := s.MustAccept()
+	defer conn.Close()
+
+	// the header carries no address so the connection's ones must be used
+	s.AssertReadPing(conn)
+	s.conns.AssertEqualToOrigin(t)
+
+	s.AssertWritePong(conn)
+	s.WaitConnClosed(conn)
+}
+
 func TestConn_ProxyProtoV2(t *testing.T) {
 	s := NewTestServer(t, 0)
 
@@ -296,6 +319,358 @@ func TestConn_ProxyProtoV2_Padded(t *testing.T) {
 	s.WaitConnClosed(conn)
 }
 
+func TestConn_ProxyHeader(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		sourceCheck SourceChecker
+		expected    *Header
+	}{
+		{
+			name:     "trusted",
+			expected: testV2Header,
+		},
+		{
+			name: "untrusted",
+			sourceCheck: func(net.Addr) (bool, error) {
+				return false, nil
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewTestServer(t, 0)
+			s.pl.SourceCheck = tt.sourceCheck
+
+			go func() {
+				rwc := &TestReadWriteCloser{
+					Header: testV2Header,
+					Conn:   s.MustClientConn(),
+				}
+				defer rwc.Close()
+				s.AssertClientReadWrite(rwc)
+			}()
+
+			conn := s.MustAccept()
+			defer conn.Close()
+
+			actual, err := conn.(*Conn).ProxyHeader()
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if !assertHeader(actual, tt.expected) {
+				t.Fatalf("expected %#v, actual %#v", tt.expected, actual)
+			}
+
+			s.AssertReadPing(conn)
+			s.AssertWritePong(conn)
+			s.WaitConnClosed(conn)
+		})
+	}
+}
+
+func TestConn_NetConn(t *testing.T) {
+	s := NewTestServer(t, 0)
+
+	go func() {
+		conn := s.MustClientConn()
+		defer conn.Close()
+		s.AssertClientReadWrite(conn)
+	}()
+
+	conn := s.MustAccept()
+	defer conn.Close()
+
+	if _, ok := conn.(*Conn).NetConn().(*net.TCPConn); !ok {
+		t.Fatalf("expected *net.TCPConn, got %T", conn.(*Conn).NetConn())
+	}
+	if conn.(*Conn).Unwrap() != conn.(*Conn).NetConn() {
+		t.Fatal("Unwrap must return the same connection as NetConn")
+	}
+
+	s.AssertReadPing(conn)
+	s.AssertWritePong(conn)
+	s.WaitConnClosed(conn)
+}
+
+func TestConn_HalfCloseAndCopy(t *testing.T) {
+	s := NewTestServer(t, 0)
+	payload := bytes.Repeat([]byte("ping"), 4096)
+
+	go func() {
+		rwc := &TestReadWriteCloser{
+			Header: testV1Header,
+			Conn:   s.MustClientConn(),
+		}
+		defer rwc.Close()
+
+		rwc.Write(payload)
+		rwc.Conn.(*net.TCPConn).CloseWrite()
+
+		// the server half-closes after echoing
+		recv, err := ioutil.ReadAll(rwc)
+		if err != nil {
+			t.Error("unexpected error:", err)
+		}
+		if !bytes.Equal(recv, payload) {
+			t.Errorf("expected %d bytes echoed, got %d bytes", len(payload), len(recv))
+		}
+	}()
+
+	conn := s.MustAccept()
+	defer conn.Close()
+
+	// Copy through WriteTo of Conn. The header must be stripped.
+	buf := &bytes.Buffer{}
+	if _, err := io.Copy(buf, conn); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if !bytes.Equal(buf.Bytes(), payload) {
+		t.Fatalf("expected %d bytes, got %d bytes", len(payload), buf.Len())
+	}
+	assertV4Addr(t, conn)
+
+	// Copy through ReadFrom of Conn
+	if _, err := io.Copy(conn, struct{ io.Reader }{buf}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := conn.(*Conn).CloseWrite(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	s.WaitConnClosed(conn)
+}
+
+func TestConn_ReadAhead(t *testing.T) {
+	s := NewTestServer(t, 0)
+	payload := []byte("ping, the payload sent along with the header")
+
+	go func() {
+		conn := s.MustClientConn()
+		defer conn.Close()
+
+		// send the header and the payload at once so that the payload is read ahead
+		buf := &bytes.Buffer{}
+		testV2Header.WriteTo(buf)
+		buf.Write(payload)
+		buf.WriteTo(conn)
+
+		s.AssertClientReadWrite(conn)
+	}()
+
+	conn := s.MustAccept()
+	defer conn.Close()
+
+	assertV6Addr(t, conn)
+
+	recv := make([]byte, 0, len(payload))
+	for len(recv) < len(payload) {
+		// read in small chunks without reading beyond the payload
+		b := make([]byte, min(3, len(payload)-len(recv)))
+		n, err := conn.Read(b)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		recv = append(recv, b[:n]...)
+	}
+	if !bytes.Equal(recv, payload) {
+		t.Fatalf("expected %q, got %q", payload, recv)
+	}
+
+	s.AssertReadPing(conn)
+	if conn.(*Conn).rest != nil {
+		t.Fatal("bytes read ahead must be released once consumed")
+	}
+	s.AssertWritePong(conn)
+	s.WaitConnClosed(conn)
+}
+
+func TestConn_ReadHeaderContext(t *testing.T) {
+	s := NewTestServer(t, 0)
+
+	clientDone := make(chan struct{})
+	go func() {
+		defer close(clientDone)
+		conn := s.MustClientConn()
+		defer conn.Close()
+
+		// never send the header and wait for the server to give up
+		s.WaitConnClosed(conn)
+	}()
+
+	// MustAccept would read the header to log addresses
+	conn, err := s.pl.Accept()
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := conn.(*Conn).ReadHeaderContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected '%v', got '%v'", context.DeadlineExceeded, err)
+	}
+
+	// the connection must be closed
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected error")
+	}
+	<-clientDone
+}
+
+func TestListener_AcceptContext(t *testing.T) {
+	t.Run("cancel while accepting", func(t *testing.T) {
+		s := NewTestServer(t, 0)
+		defer s.ln.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		if _, err := s.pl.AcceptContext(ctx); err != context.Canceled {
+			t.Fatalf("expected '%v', got '%v'", context.Canceled, err)
+		}
+
+		// the listener must still be usable
+		go func() {
+			conn := s.MustClientConn()
+			defer conn.Close()
+			s.AssertClientReadWrite(conn)
+		}()
+		conn, err := s.pl.AcceptContext(context.Background())
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		defer conn.Close()
+		s.AssertReadPing(conn)
+		s.AssertWritePong(conn)
+		s.WaitConnClosed(conn)
+	})
+
+	t.Run("cancel doesn't interrupt other accepts", func(t *testing.T) {
+		s := NewTestServer(t, 0)
+		defer s.pl.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelled := make(chan error, 1)
+		go func() {
+			_, err := s.pl.AcceptContext(ctx)
+			cancelled <- err
+		}()
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := s.pl.AcceptContext(context.Background())
+			if err != nil {
+				t.Error("unexpected error:", err)
+			}
+			accepted <- conn
+		}()
+
+		cancel()
+		if err := <-cancelled; err != context.Canceled {
+			t.Fatalf("expected '%v', got '%v'", context.Canceled, err)
+		}
+
+		go func() {
+			conn := s.MustClientConn()
+			defer conn.Close()
+			s.AssertClientReadWrite(conn)
+		}()
+		conn := <-accepted
+		if conn == nil {
+			t.FailNow()
+		}
+		defer conn.Close()
+		s.AssertReadPing(conn)
+		s.AssertWritePong(conn)
+		s.WaitConnClosed(conn)
+	})
+
+	t.Run("close while accepting", func(t *testing.T) {
+		s := NewTestServer(t, 0)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		time.AfterFunc(50*time.Millisecond, func() { s.pl.Close() })
+		if _, err := s.pl.AcceptContext(ctx); !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("expected '%v', got '%v'", net.ErrClosed, err)
+		}
+	})
+
+	t.Run("cancel while reading the header", func(t *testing.T) {
+		s := NewTestServer(t, 0)
+		defer s.ln.Close()
+
+		go func() {
+			conn := s.MustClientConn()
+			defer conn.Close()
+			s.WaitConnClosed(conn)
+		}()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		conn, err := s.pl.AcceptContext(ctx)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		defer conn.Close()
+
+		time.AfterFunc(50*time.Millisecond, cancel)
+		if _, err := conn.Read(make([]byte, 1)); err != context.Canceled {
+			t.Fatalf("expected '%v', got '%v'", context.Canceled, err)
+		}
+	})
+}
+
+func TestConn_ReadDeadline(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		timeout    time.Duration
+		sendHeader bool
+	}{
+		{
+			name:       "restored after the header",
+			timeout:    time.Minute,
+			sendHeader: true,
+		},
+		{
+			name:    "tighter than ProxyHeaderTimeout",
+			timeout: time.Minute,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewTestServer(t, tt.timeout)
+
+			clientDone := make(chan struct{})
+			go func() {
+				defer close(clientDone)
+				conn := s.MustClientConn()
+				defer conn.Close()
+				if tt.sendHeader {
+					testV1Header.WriteTo(conn)
+				}
+				// send nothing else and wait for the server to give up
+				s.WaitConnClosed(conn)
+			}()
+
+			conn, err := s.pl.Accept()
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			start := time.Now()
+			conn.SetReadDeadline(start.Add(100 * time.Millisecond))
+			_, err = conn.Read(make([]byte, 1))
+			if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+				t.Fatalf("expected timeout, got '%v'", err)
+			}
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Fatalf("the read deadline must be respected but took %s", elapsed)
+			}
+			if tt.sendHeader {
+				assertV4Addr(t, conn)
+			}
+
+			conn.Close()
+			<-clientDone
+		})
+	}
+}
+
 func TestConn_Invalid(t *testing.T) {
 	s := NewTestServer(t, 0)
 
@@ -306,7 +681,7 @@ func TestConn_Invalid(t *testing.T) {
 		go func() {
 			conn := s.MustClientConn()
 			if _, err := conn.Write(b); err != nil {
-				t.Fatal("unexpected error:", err)
+				t.Error("unexpected error:", err)
 			}
 		}()
 
@@ -321,6 +696,101 @@ func TestConn_Invalid(t *testing.T) {
 	}
 }
 
+func TestListener_Reject(t *testing.T) {
+	s := NewTestServer(t, 0)
+
+	var rejectedAddr net.Addr
+	var rejectedErr error
+	var checked int
+	s.pl.SourceCheck = func(net.Addr) (bool, error) {
+		checked++
+		if checked == 1 {
+			return false, ErrInvalidUpstream
+		}
+		return true, nil
+	}
+	s.pl.OnReject = func(conn net.Conn, err error) {
+		rejectedAddr = conn.RemoteAddr()
+		rejectedErr = err
+	}
+
+	rejected := s.MustClientConn()
+	defer rejected.Close()
+	go func() {
+		rwc := &TestReadWriteCloser{
+			Header: testV1Header,
+			Conn:   s.MustClientConn(),
+		}
+		defer rwc.Close()
+		s.AssertClientReadWrite(rwc)
+	}()
+
+	conn := s.MustAccept()
+	defer conn.Close()
+
+	if s.pl.Rejected() != 1 {
+		t.Errorf("expected 1 rejected connection, got %d", s.pl.Rejected())
+	}
+	if rejectedErr != ErrInvalidUpstream || rejectedAddr.String() != rejected.LocalAddr().String() {
+		t.Errorf("expected OnReject with '%v' from %s, got '%v' from %v", ErrInvalidUpstream, rejected.LocalAddr(), rejectedErr, rejectedAddr)
+	}
+	// the rejected connection must be closed
+	if _, err := io.Copy(ioutil.Discard, rejected); err != nil {
+		t.Error("unexpected error:", err)
+	}
+
+	assertV4Addr(t, conn)
+	s.AssertReadPing(conn)
+	s.AssertWritePong(conn)
+}
+
+func TestConn_StickyError(t *testing.T) {
+	s := NewTestServer(t, 0)
+
+	clientDone := make(chan struct{})
+	go func() {
+		defer close(clientDone)
+		conn := s.MustClientConn()
+		defer conn.Close()
+		// an invalid address family followed by what looks like the rest of the header
+		conn.Write(catBytes(SIGV2, proxyBytes, invalidBytes, fixedV4AddrLen[:], fixtureIPv4Address, []byte("ping")))
+
+		// the server must close the connection
+		if _, err := io.Copy(ioutil.Discard, conn); err != nil {
+			t.Error("unexpected error:", err)
+		}
+	}()
+
+	conn, err := s.pl.Accept()
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Read(make([]byte, 4))
+	if !errors.Is(err, ErrUnsupportedAddressFamilyAndProtocol) {
+		t.Fatalf("expected '%v', got '%v'", ErrUnsupportedAddressFamilyAndProtocol, err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, actual := conn.Read(make([]byte, 4)); actual != err {
+			t.Errorf("expected the same error '%v', got '%v'", err, actual)
+		}
+		if _, actual := io.Copy(ioutil.Discard, conn); actual != err {
+			t.Errorf("expected the same error '%v', got '%v'", err, actual)
+		}
+		if _, actual := conn.(*Conn).ProxyHeader(); actual != err {
+			t.Errorf("expected the same error '%v', got '%v'", err, actual)
+		}
+		if actual := conn.(*Conn).ReadHeaderContext(context.Background()); actual != err {
+			t.Errorf("expected the same error '%v', got '%v'", err, actual)
+		}
+	}
+	if conn.RemoteAddr().String() != conn.(*Conn).NetConn().RemoteAddr().String() {
+		t.Errorf("expected the address of the socket peer, got '%s'", conn.RemoteAddr())
+	}
+	<-clientDone
+}
+
 func TestConn_Timeout(t *testing.T) {
 	s := NewTestServer(t, 50*time.Millisecond)
 
@@ -342,6 +812,109 @@ func TestConn_Timeout(t *testing.T) {
 	s.WaitConnClosed(conn)
 }
 
+func TestConn_Timeout_Reject(t *testing.T) {
+	s := NewTestServer(t, 50*time.Millisecond)
+	s.pl.HeaderTimeoutPolicy = TimeoutReject
+
+	clientDone := make(chan struct{})
+	go func() {
+		defer close(clientDone)
+		conn := s.MustClientConn()
+		defer conn.Close()
+		time.Sleep(200 * time.Millisecond)
+		conn.Write([]byte("ping"))
+		s.WaitConnClosed(conn)
+	}()
+
+	conn, err := s.pl.Accept()
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Read(make([]byte, 4)); err != ErrHeaderTimeout {
+		t.Fatalf("expected '%v', got '%v'", ErrHeaderTimeout, err)
+	}
+	if _, err := conn.(*Conn).ProxyHeader(); err != ErrHeaderTimeout {
+		t.Fatalf("expected '%v', got '%v'", ErrHeaderTimeout, err)
+	}
+	<-clientDone
+}
+
+func TestConn_LargeHeader(t *testing.T) {
+	hdr := *testV2Header
+	hdr.TLVs = []TLV{{Type: PP2_TYPE_SSL, Value: make([]byte, 8192)}}
+
+	for _, tt := range []struct {
+		name          string
+		maxHeaderSize int
+		expectedError error
+	}{
+		{
+			name: "no limit",
+		},
+		{
+			name:          "too large",
+			maxHeaderSize: 4096,
+			expectedError: ErrHeaderTooLarge,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewTestServer(t, 0)
+			s.pl.MaxHeaderSize = tt.maxHeaderSize
+
+			go func() {
+				rwc := &TestReadWriteCloser{
+					Header: &hdr,
+					Conn:   s.MustClientConn(),
+				}
+				defer rwc.Close()
+				rwc.Write([]byte("ping"))
+				s.WaitConnClosed(rwc.Conn)
+			}()
+
+			conn, err := s.pl.Accept()
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			defer conn.Close()
+
+			actual, err := conn.(*Conn).ProxyHeader()
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("expected '%v', got '%v'", tt.expectedError, err)
+			}
+			if tt.expectedError != nil {
+				return
+			}
+			if !assertHeader(actual, &hdr) {
+				t.Errorf("expected %v, got %v", &hdr, actual)
+			}
+			s.AssertReadPing(conn)
+		})
+	}
+}
+
+func TestConn_useHeaderAddr(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		header      *Header
+		useConnAddr bool
+		expected    bool
+	}{
+		{name: "no header"},
+		{name: "TCPv4", header: testV1Header, expected: true},
+		{name: "UNSPEC", header: &Header{Version: 2, Command: PROXY, TransportProtocol: UNSPEC, SrcAddr: v4addr, DstAddr: v4addr}},
+		{name: "upstream not trusted", header: testV1Header, useConnAddr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &Conn{header: tt.header, useConnAddr: tt.useConnAddr}
+			if actual := conn.useHeaderAddr(); actual != tt.expected {
+				t.Errorf("expected %v, actual %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
 func assertV4Addr(t *testing.T, conn net.Conn) {
 	if conn.LocalAddr().String() != v4AddrPort {
 		t.Fatalf("expected '%s', got '%s'", v4AddrPort, conn.LocalAddr().String())
@@ -0,0 +1,110 @@
+package proxyproto
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrLossyConversion = errors.New("proxyproto: lossy conversion")
+)
+
+// ConversionError lists what could not be represented when converting a
+// header to another version. It wraps ErrLossyConversion.
+type ConversionError struct {
+	// Version is the version converted to.
+	Version int
+
+	// Lost describes each piece of information that was dropped, e.g. "TLVs".
+	Lost []string
+}
+
+func (e *ConversionError) Error() string {
+	return fmt.Sprintf("%v to v%d: %s", ErrLossyConversion, e.Version, strings.Join(e.Lost, ", "))
+}
+
+func (e *ConversionError) Unwrap() error {
+	return ErrLossyConversion
+}
+
+// ConvertTo returns a copy of the header converted to the given version.
+//
+// Every v1 header can be represented in v2. The other way round, v1 has no
+// TLVs, no LOCAL command and only TCP over IPv4 or IPv6, so TLVs are dropped
+// and anything else than TCP is converted to "PROXY UNKNOWN", telling the
+// receiver to use the real connection endpoints. In that case ConvertTo
+// returns the converted header together with a *ConversionError describing
+// what was lost, so that callers can decide whether to use it:
+//
+//	v1, err := hdr.ConvertTo(1)
+//	if err != nil && !errors.Is(err, proxyproto.ErrLossyConversion) {
+//		return err
+//	}
+func (h *Header) ConvertTo(version int) (*Header, error) {
+	hdr := &Header{
+		Version:           version,
+		Command:           h.Command,
+		TransportProtocol: h.TransportProtocol,
+		SrcAddr:           h.SrcAddr,
+		DstAddr:           h.DstAddr,
+		SrcPort:           h.SrcPort,
+		DstPort:           h.DstPort,
+	}
+
+	switch version {
+	case 1:
+		lost := v1Losses(h)
+		if h.Command.IsLocal() || !isV1TransportProtocol(h.TransportProtocol) {
+			// PROXY UNKNOWN
+			hdr.TransportProtocol = UNSPEC
+			hdr.SrcAddr, hdr.DstAddr = nil, nil
+			hdr.SrcPort, hdr.DstPort = 0, 0
+		}
+		hdr.Command = PROXY
+		if len(lost) > 0 {
+			return hdr, &ConversionError{Version: version, Lost: lost}
+		}
+		return hdr, nil
+	case 2:
+		if h.Version == 1 {
+			// v1 has no command, every header is PROXY
+			hdr.Command = PROXY
+		}
+		hdr.TLVs = append([]TLV(nil), h.TLVs...)
+		hdr.PadSize, hdr.PadAlign = h.PadSize, h.PadAlign
+		return hdr, nil
+	}
+	return nil, ErrUnknownProxyProtocolVersion
+}
+
+// isV1TransportProtocol returns true if proto can be represented in v1.
+func isV1TransportProtocol(proto AddressFamilyAndProtocol) bool {
+	switch proto {
+	case TCPv4, TCPv6, UNSPEC:
+		return true
+	}
+	return false
+}
+
+// v1Losses describes what in h cannot be represented in v1.
+func v1Losses(h *Header) []string {
+	var lost []string
+	if h.Command.IsLocal() {
+		// addresses of LOCAL headers are meaningless so only the command is lost
+		lost = append(lost, "LOCAL command")
+	}
+	if len(h.TLVs) > 0 {
+		lost = append(lost, "TLVs")
+	}
+	switch {
+	case h.Command.IsLocal() || isV1TransportProtocol(h.TransportProtocol):
+	case h.TransportProtocol&0xF0 == 0x30:
+		lost = append(lost, "Unix socket addresses")
+	case h.TransportProtocol.IsDatagram():
+		lost = append(lost, "UDP addresses")
+	default:
+		lost = append(lost, fmt.Sprintf("addresses of address family and protocol %s", h.TransportProtocol))
+	}
+	return lost
+}
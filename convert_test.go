@@ -0,0 +1,131 @@
+package proxyproto
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestHeader_ConvertTo(t *testing.T) {
+	tcpv4 := &Header{
+		Version:           2,
+		Command:           PROXY,
+		TransportProtocol: TCPv4,
+		SrcAddr:           v4addr,
+		DstAddr:           v4addr,
+		SrcPort:           PORT,
+		DstPort:           PORT,
+	}
+	unknown := &Header{
+		Version:           1,
+		Command:           PROXY,
+		TransportProtocol: UNSPEC,
+	}
+
+	for _, tt := range []struct {
+		name         string
+		header       *Header
+		version      int
+		expected     *Header
+		expectedLost []string
+	}{
+		{
+			name:     "v2 TCPv4 to v1",
+			header:   tcpv4,
+			version:  1,
+			expected: &Header{Version: 1, Command: PROXY, TransportProtocol: TCPv4, SrcAddr: v4addr, DstAddr: v4addr, SrcPort: PORT, DstPort: PORT},
+		},
+		{
+			name: "v2 TCPv6 with TLVs to v1",
+			header: &Header{
+				Version:           2,
+				Command:           PROXY,
+				TransportProtocol: TCPv6,
+				SrcAddr:           v6addr,
+				DstAddr:           v6addr,
+				SrcPort:           PORT,
+				DstPort:           PORT,
+				TLVs:              fixtureTLVs,
+			},
+			version:      1,
+			expected:     &Header{Version: 1, Command: PROXY, TransportProtocol: TCPv6, SrcAddr: v6addr, DstAddr: v6addr, SrcPort: PORT, DstPort: PORT},
+			expectedLost: []string{"TLVs"},
+		},
+		{
+			name:         "v2 UDPv4 to v1",
+			header:       &Header{Version: 2, Command: PROXY, TransportProtocol: UDPv4, SrcAddr: v4addr, DstAddr: v4addr, SrcPort: PORT, DstPort: PORT},
+			version:      1,
+			expected:     unknown,
+			expectedLost: []string{"UDP addresses"},
+		},
+		{
+			name:         "v2 Unix stream to v1",
+			header:       &Header{Version: 2, Command: PROXY, TransportProtocol: 0x31},
+			version:      1,
+			expected:     unknown,
+			expectedLost: []string{"Unix socket addresses"},
+		},
+		{
+			name:         "v2 LOCAL to v1",
+			header:       &Header{Version: 2, Command: LOCAL, TransportProtocol: TCPv4, SrcAddr: v4addr, DstAddr: v4addr, TLVs: fixtureTLVs},
+			version:      1,
+			expected:     unknown,
+			expectedLost: []string{"LOCAL command", "TLVs"},
+		},
+		{
+			name:     "v2 PROXY UNSPEC to v1",
+			header:   &Header{Version: 2, Command: PROXY, TransportProtocol: UNSPEC},
+			version:  1,
+			expected: unknown,
+		},
+		{
+			name:     "v1 TCPv4 to v2",
+			header:   &Header{Version: 1, TransportProtocol: TCPv4, SrcAddr: v4addr, DstAddr: v4addr, SrcPort: PORT, DstPort: PORT},
+			version:  2,
+			expected: tcpv4,
+		},
+		{
+			name:     "v1 UNKNOWN to v2",
+			header:   unknown,
+			version:  2,
+			expected: &Header{Version: 2, Command: PROXY, TransportProtocol: UNSPEC},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := tt.header.ConvertTo(tt.version)
+			if tt.expectedLost == nil {
+				if err != nil {
+					t.Fatal("unexpected error:", err)
+				}
+			} else {
+				var cerr *ConversionError
+				if !errors.As(err, &cerr) || !errors.Is(err, ErrLossyConversion) {
+					t.Fatalf("expected *ConversionError, actual %#v", err)
+				}
+				if cerr.Version != tt.version || !reflect.DeepEqual(cerr.Lost, tt.expectedLost) {
+					t.Errorf("expected %v lost in v%d, actual %#v", tt.expectedLost, tt.version, cerr)
+				}
+			}
+			if actual.Version != tt.expected.Version || actual.Command != tt.expected.Command || !assertHeader(actual, tt.expected) {
+				t.Errorf("expected %v, actual %v", tt.expected, actual)
+			}
+			assertRoundTrip(t, actual)
+		})
+	}
+}
+
+func TestHeader_ConvertToUnknownVersion(t *testing.T) {
+	hdr := &Header{Version: 1, Command: PROXY, TransportProtocol: TCPv4, SrcAddr: net.IPv4(10, 0, 0, 1), DstAddr: net.IPv4(10, 0, 0, 2)}
+	if _, err := hdr.ConvertTo(3); err != ErrUnknownProxyProtocolVersion {
+		t.Errorf("expected '%v', actual '%v'", ErrUnknownProxyProtocolVersion, err)
+	}
+}
+
+func TestConversionError(t *testing.T) {
+	err := &ConversionError{Version: 1, Lost: []string{"LOCAL command", "TLVs"}}
+	expected := "proxyproto: lossy conversion to v1: LOCAL command, TLVs"
+	if err.Error() != expected {
+		t.Errorf("expected %q, actual %q", expected, err.Error())
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/nabeken/go-proxyproto
+
+go 1.21
@@ -0,0 +1,313 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MarshalText implements encoding.TextMarshaler. See String.
+func (pvc ProtocolVersionAndCommand) MarshalText() ([]byte, error) {
+	return []byte(pvc.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It accepts the output of
+// String, i.e. "LOCAL", "PROXY" or a byte in hex.
+func (pvc *ProtocolVersionAndCommand) UnmarshalText(text []byte) error {
+	switch s := string(text); s {
+	case "LOCAL":
+		*pvc = LOCAL
+	case "PROXY":
+		*pvc = PROXY
+	default:
+		b, err := parseHexByte(s)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrUnsupportedProtocolVersionAndCommand, s)
+		}
+		*pvc = ProtocolVersionAndCommand(b)
+	}
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler. See String.
+func (ap AddressFamilyAndProtocol) MarshalText() ([]byte, error) {
+	return []byte(ap.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It accepts the output of
+// String, e.g. "TCPv4", or a byte in hex.
+func (ap *AddressFamilyAndProtocol) UnmarshalText(text []byte) error {
+	s := string(text)
+	for _, proto := range []AddressFamilyAndProtocol{UNSPEC, TCPv4, UDPv4, TCPv6, UDPv6} {
+		if s == proto.String() {
+			*ap = proto
+			return nil
+		}
+	}
+	b, err := parseHexByte(s)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrUnsupportedAddressFamilyAndProtocol, s)
+	}
+	*ap = AddressFamilyAndProtocol(b)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler. See String.
+func (t PP2Type) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It accepts the output of
+// String, e.g. "ALPN", or a byte in hex.
+func (t *PP2Type) UnmarshalText(text []byte) error {
+	s := string(text)
+	b, err := parseHexByte(s)
+	if err == nil {
+		*t = PP2Type(b)
+		return nil
+	}
+	for typ := 0; typ <= 0xff; typ++ {
+		if s == PP2Type(typ).String() {
+			*t = PP2Type(typ)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown type %q", ErrInvalidTLV, s)
+}
+
+// parseHexByte parses a byte formatted as 0x%02x.
+func parseHexByte(s string) (byte, error) {
+	if !strings.HasPrefix(s, "0x") {
+		return 0, strconv.ErrSyntax
+	}
+	b, err := strconv.ParseUint(s[2:], 16, 8)
+	return byte(b), err
+}
+
+// tlvJSON is the JSON representation of a TLV. Exactly one of the value
+// fields is set: Value for the textual types ALPN and AUTHORITY, CRC32C for
+// the checksum and Hex for anything else.
+type tlvJSON struct {
+	Type   PP2Type `json:"type"`
+	Value  *string `json:"value,omitempty"`
+	CRC32C *uint32 `json:"crc32c,omitempty"`
+	Hex    *string `json:"hex,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+// Values of custom types registered with RegisterTLVType are encoded from
+// Decoded, when set, as by WriteTo.
+func (t TLV) MarshalJSON() ([]byte, error) {
+	value, err := encodeTLV(t)
+	if err != nil {
+		return nil, err
+	}
+	v := tlvJSON{Type: t.Type}
+	switch {
+	case (t.Type == PP2_TYPE_ALPN || t.Type == PP2_TYPE_AUTHORITY) && utf8.Valid(value):
+		s := string(value)
+		v.Value = &s
+	case t.Type == PP2_TYPE_CRC32C && len(value) == 4:
+		sum := binary.BigEndian.Uint32(value)
+		v.CRC32C = &sum
+	default:
+		s := hex.EncodeToString(value)
+		v.Hex = &s
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Values of custom types
+// registered with RegisterTLVType are decoded into Decoded, as by Read.
+func (t *TLV) UnmarshalJSON(data []byte) error {
+	var v tlvJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	var value []byte
+	n := 0
+	if v.Value != nil {
+		value = []byte(*v.Value)
+		n++
+	}
+	if v.CRC32C != nil {
+		value = binary.BigEndian.AppendUint32(nil, *v.CRC32C)
+		n++
+	}
+	if v.Hex != nil {
+		var err error
+		if value, err = hex.DecodeString(*v.Hex); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidTLV, v.Type, err)
+		}
+		n++
+	}
+	if n != 1 {
+		return fmt.Errorf("%w: %s: exactly one of value, crc32c and hex must be given", ErrInvalidTLV, v.Type)
+	}
+
+	*t = TLV{Type: v.Type, Value: value}
+	decodeTLV(t)
+	return nil
+}
+
+// headerJSON is the JSON representation of a Header.
+type headerJSON struct {
+	Version            int                       `json:"version"`
+	Command            ProtocolVersionAndCommand `json:"command"`
+	Protocol           AddressFamilyAndProtocol  `json:"protocol"`
+	SourceAddress      net.IP                    `json:"source_address,omitempty"`
+	SourcePort         uint16                    `json:"source_port,omitempty"`
+	DestinationAddress net.IP                    `json:"destination_address,omitempty"`
+	DestinationPort    uint16                    `json:"destination_port,omitempty"`
+	TLVs               []TLV                     `json:"tlvs,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, e.g.
+//
+//	{"version":2,"command":"PROXY","protocol":"TCPv4",
+//	 "source_address":"127.0.0.1","source_port":56324,
+//	 "destination_address":"127.0.0.2","destination_port":443,
+//	 "tlvs":[{"type":"ALPN","value":"h2"},{"type":"0xe5","hex":"0100"}]}
+func (h *Header) MarshalJSON() ([]byte, error) {
+	return json.Marshal(headerJSON{
+		Version:            h.Version,
+		Command:            h.Command,
+		Protocol:           h.TransportProtocol,
+		SourceAddress:      h.SrcAddr,
+		SourcePort:         h.SrcPort,
+		DestinationAddress: h.DstAddr,
+		DestinationPort:    h.DstPort,
+		TLVs:               h.TLVs,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (h *Header) UnmarshalJSON(data []byte) error {
+	var v headerJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*h = Header{
+		Version:           v.Version,
+		Command:           v.Command,
+		TransportProtocol: v.Protocol,
+		SrcAddr:           v.SourceAddress,
+		SrcPort:           v.SourcePort,
+		DstAddr:           v.DestinationAddress,
+		DstPort:           v.DestinationPort,
+		TLVs:              v.TLVs,
+	}
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler. The header is rendered as
+// space separated key=value pairs with TLV values in hex, e.g.
+//
+//	version=2 command=PROXY protocol=TCPv4 src=127.0.0.1:56324 dst=127.0.0.2:443 tlv=ALPN:6832
+//
+// Unlike String, the output is meant to be read back with UnmarshalText.
+func (h *Header) MarshalText() ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "version=%d command=%s protocol=%s", h.Version, h.Command, h.TransportProtocol)
+	if h.SrcAddr != nil || h.SrcPort != 0 {
+		fmt.Fprintf(&b, " src=%s", joinHostPort(h.SrcAddr, h.SrcPort))
+	}
+	if h.DstAddr != nil || h.DstPort != 0 {
+		fmt.Fprintf(&b, " dst=%s", joinHostPort(h.DstAddr, h.DstPort))
+	}
+	for _, tlv := range h.TLVs {
+		value, err := encodeTLV(tlv)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, " tlv=%s:%x", tlv.Type, value)
+	}
+	return []byte(b.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It accepts the output of MarshalText.
+func (h *Header) UnmarshalText(text []byte) error {
+	var hdr Header
+	for _, field := range strings.Fields(string(text)) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return fmt.Errorf("proxyproto: invalid header field %q", field)
+		}
+
+		var err error
+		switch key {
+		case "version":
+			hdr.Version, err = strconv.Atoi(value)
+		case "command":
+			err = hdr.Command.UnmarshalText([]byte(value))
+		case "protocol":
+			err = hdr.TransportProtocol.UnmarshalText([]byte(value))
+		case "src":
+			hdr.SrcAddr, hdr.SrcPort, err = splitHostPort(value)
+		case "dst":
+			hdr.DstAddr, hdr.DstPort, err = splitHostPort(value)
+		case "tlv":
+			var tlv TLV
+			tlv, err = parseTextTLV(value)
+			hdr.TLVs = append(hdr.TLVs, tlv)
+		default:
+			err = errors.New("unknown key")
+		}
+		if err != nil {
+			return fmt.Errorf("proxyproto: invalid header field %q: %w", field, err)
+		}
+	}
+	*h = hdr
+	return nil
+}
+
+func joinHostPort(ip net.IP, port uint16) string {
+	var host string
+	if ip != nil {
+		host = ip.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+func splitHostPort(s string) (net.IP, uint16, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil, 0, err
+	}
+	var ip net.IP
+	if host != "" {
+		if ip = net.ParseIP(host); ip == nil {
+			return nil, 0, ErrInvalidAddress
+		}
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, 0, ErrInvalidPortNumber
+	}
+	return ip, uint16(p), nil
+}
+
+func parseTextTLV(s string) (TLV, error) {
+	typ, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return TLV{}, fmt.Errorf("%w: must be type:hex", ErrInvalidTLV)
+	}
+	var tlv TLV
+	if err := tlv.Type.UnmarshalText([]byte(typ)); err != nil {
+		return TLV{}, err
+	}
+	b, err := hex.DecodeString(value)
+	if err != nil {
+		return TLV{}, fmt.Errorf("%w: %v", ErrInvalidTLV, err)
+	}
+	tlv.Value = b
+	decodeTLV(&tlv)
+	return tlv, nil
+}
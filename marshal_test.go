@@ -0,0 +1,196 @@
+package proxyproto
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+)
+
+var fixtureMarshalHeader = &Header{
+	Version:           2,
+	Command:           PROXY,
+	TransportProtocol: TCPv4,
+	SrcAddr:           net.ParseIP("127.0.0.1"),
+	DstAddr:           net.ParseIP("127.0.0.2"),
+	SrcPort:           56324,
+	DstPort:           443,
+	TLVs: []TLV{
+		{Type: PP2_TYPE_ALPN, Value: []byte("h2")},
+		{Type: PP2_TYPE_CRC32C, Value: []byte{0x01, 0x02, 0x03, 0x04}},
+		{Type: PP2_TYPE_AUTHORITY, Value: []byte{0xff}},
+		{Type: 0xE5, Value: []byte{0x01, 0x00}},
+	},
+}
+
+func TestHeader_MarshalJSON(t *testing.T) {
+	actual, err := json.Marshal(fixtureMarshalHeader)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expected := `{"version":2,"command":"PROXY","protocol":"TCPv4",` +
+		`"source_address":"127.0.0.1","source_port":56324,` +
+		`"destination_address":"127.0.0.2","destination_port":443,` +
+		`"tlvs":[{"type":"ALPN","value":"h2"},{"type":"CRC32C","crc32c":16909060},` +
+		`{"type":"AUTHORITY","hex":"ff"},{"type":"0xe5","hex":"0100"}]}`
+	if string(actual) != expected {
+		t.Errorf("expected\n%s\nactual\n%s", expected, actual)
+	}
+}
+
+func TestHeader_MarshalText(t *testing.T) {
+	actual, err := fixtureMarshalHeader.MarshalText()
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expected := "version=2 command=PROXY protocol=TCPv4 src=127.0.0.1:56324 dst=127.0.0.2:443 " +
+		"tlv=ALPN:6832 tlv=CRC32C:01020304 tlv=AUTHORITY:ff tlv=0xe5:0100"
+	if string(actual) != expected {
+		t.Errorf("expected\n%s\nactual\n%s", expected, actual)
+	}
+}
+
+func TestHeader_MarshalRoundTrip(t *testing.T) {
+	headers := []*Header{
+		fixtureMarshalHeader,
+		{Version: 2, Command: LOCAL, TransportProtocol: UNSPEC},
+		{Version: 2, Command: 0x2F, TransportProtocol: 0x31, TLVs: fixtureTLVs},
+	}
+	for _, tt := range conformanceCorpus {
+		if tt.expectedHeader != nil {
+			headers = append(headers, tt.expectedHeader)
+		}
+	}
+
+	for _, hdr := range headers {
+		assertMarshalRoundTrip(t, hdr)
+	}
+}
+
+func TestHeader_UnmarshalInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		json          string
+		text          string
+		expectedError error
+	}{
+		{
+			name:          "command",
+			json:          `{"version":2,"command":"PROXYY"}`,
+			text:          "command=PROXYY",
+			expectedError: ErrUnsupportedProtocolVersionAndCommand,
+		},
+		{
+			name:          "protocol",
+			json:          `{"version":2,"protocol":"TCP4"}`,
+			text:          "protocol=TCP4",
+			expectedError: ErrUnsupportedAddressFamilyAndProtocol,
+		},
+		{
+			name:          "TLV type",
+			json:          `{"version":2,"tlvs":[{"type":"FOO","hex":""}]}`,
+			text:          "tlv=FOO:",
+			expectedError: ErrInvalidTLV,
+		},
+		{
+			name:          "TLV value",
+			json:          `{"version":2,"tlvs":[{"type":"ALPN","hex":"zz"}]}`,
+			text:          "tlv=ALPN:zz",
+			expectedError: ErrInvalidTLV,
+		},
+		{
+			name:          "TLV without value",
+			json:          `{"version":2,"tlvs":[{"type":"ALPN"}]}`,
+			text:          "tlv=ALPN",
+			expectedError: ErrInvalidTLV,
+		},
+		{
+			name:          "TLV with two values",
+			json:          `{"version":2,"tlvs":[{"type":"ALPN","value":"h2","hex":"6832"}]}`,
+			expectedError: ErrInvalidTLV,
+		},
+		{
+			name:          "address",
+			text:          "src=localhost:80",
+			expectedError: ErrInvalidAddress,
+		},
+		{
+			name:          "port",
+			text:          "src=127.0.0.1:65536",
+			expectedError: ErrInvalidPortNumber,
+		},
+		{
+			name: "unknown key",
+			text: "foo=bar",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.json != "" {
+				var hdr Header
+				err := json.Unmarshal([]byte(tt.json), &hdr)
+				if err == nil || tt.expectedError != nil && !errors.Is(err, tt.expectedError) {
+					t.Errorf("JSON: expected '%v', actual '%v'", tt.expectedError, err)
+				}
+			}
+			if tt.text != "" {
+				var hdr Header
+				err := hdr.UnmarshalText([]byte(tt.text))
+				if err == nil || tt.expectedError != nil && !errors.Is(err, tt.expectedError) {
+					t.Errorf("text: expected '%v', actual '%v'", tt.expectedError, err)
+				}
+			}
+		})
+	}
+}
+
+func FuzzHeaderMarshal(f *testing.F) {
+	for _, tt := range conformanceCorpus {
+		f.Add(tt.bytes)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		hdr, err := Read(newBufioReader(b))
+		if err != nil || hdr == nil {
+			return
+		}
+		assertMarshalRoundTrip(t, hdr)
+	})
+}
+
+// assertMarshalRoundTrip asserts that hdr is unmarshaled unchanged after
+// being marshaled as JSON and as text.
+func assertMarshalRoundTrip(t *testing.T, hdr *Header) {
+	t.Helper()
+
+	b, err := json.Marshal(hdr)
+	if err != nil {
+		t.Fatalf("failed to marshal %#v as JSON: %v", hdr, err)
+	}
+	actual := &Header{}
+	if err := json.Unmarshal(b, actual); err != nil {
+		t.Fatalf("failed to unmarshal %s: %v", b, err)
+	}
+	if !assertMarshaledHeader(actual, hdr) {
+		t.Errorf("JSON: expected %#v, actual %#v (marshaled %s)", hdr, actual, b)
+	}
+
+	b, err = hdr.MarshalText()
+	if err != nil {
+		t.Fatalf("failed to marshal %#v as text: %v", hdr, err)
+	}
+	actual = &Header{}
+	if err := actual.UnmarshalText(b); err != nil {
+		t.Fatalf("failed to unmarshal %q: %v", b, err)
+	}
+	if !assertMarshaledHeader(actual, hdr) {
+		t.Errorf("text: expected %#v, actual %#v (marshaled %q)", hdr, actual, b)
+	}
+}
+
+func assertMarshaledHeader(actual, expected *Header) bool {
+	return actual.Version == expected.Version &&
+		actual.Command == expected.Command &&
+		actual.SrcAddr.Equal(expected.SrcAddr) &&
+		actual.DstAddr.Equal(expected.DstAddr) &&
+		assertHeader(actual, expected)
+}
@@ -0,0 +1,99 @@
+package proxyproto
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// netnsDir is where named network namespaces are bound, as by "ip netns add".
+var netnsDir = "/var/run/netns"
+
+// DialNetNS connects to address in the network namespace named by the NETNS
+// TLV of the header. See DialNetNS.
+func (h *Header) DialNetNS(network, address string) (net.Conn, error) {
+	name, ok := h.NetNS()
+	if !ok {
+		return nil, errors.New("proxyproto: no NETNS TLV")
+	}
+	return DialNetNS(name, network, address)
+}
+
+// DialNetNS connects to address in the named network namespace of
+// /var/run/netns. It requires CAP_SYS_ADMIN. address should hold an IP
+// address: host names are resolved outside of the namespace.
+func DialNetNS(name, network, address string) (net.Conn, error) {
+	var conn net.Conn
+	err := withNetNS(name, func() error {
+		// Racing IPv4 and IPv6 would dial from other threads.
+		d := net.Dialer{FallbackDelay: -1}
+		var err error
+		conn, err = d.Dial(network, address)
+		return err
+	})
+	return conn, err
+}
+
+// ListenNetNS listens on address in the named network namespace of
+// /var/run/netns. It requires CAP_SYS_ADMIN. The accepted connections belong
+// to the namespace as well.
+func ListenNetNS(name, network, address string) (net.Listener, error) {
+	var ln net.Listener
+	err := withNetNS(name, func() error {
+		var err error
+		ln, err = net.Listen(network, address)
+		return err
+	})
+	return ln, err
+}
+
+// withNetNS calls fn from a thread switched to the named network namespace
+// so that the sockets it creates belong to the namespace.
+func withNetNS(name string, fn func() error) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/') {
+		return fmt.Errorf("proxyproto: invalid network namespace %q", name)
+	}
+	ns, err := os.Open(filepath.Join(netnsDir, name))
+	if err != nil {
+		return fmt.Errorf("proxyproto: failed to open network namespace: %w", err)
+	}
+	defer ns.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		// The thread is only unlocked once back in its namespace. Otherwise
+		// it is terminated when the goroutine exits.
+		runtime.LockOSThread()
+
+		orig, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid()))
+		if err != nil {
+			errc <- fmt.Errorf("proxyproto: failed to open current network namespace: %w", err)
+			return
+		}
+		defer orig.Close()
+
+		if err := setns(ns, syscall.CLONE_NEWNET); err != nil {
+			errc <- fmt.Errorf("proxyproto: failed to enter network namespace %q: %w", name, err)
+			return
+		}
+		err = fn()
+		if setns(orig, syscall.CLONE_NEWNET) == nil {
+			runtime.UnlockOSThread()
+		}
+		errc <- err
+	}()
+	return <-errc
+}
+
+func setns(f *os.File, nstype int) error {
+	_, _, errno := syscall.RawSyscall(sysSetns, f.Fd(), uintptr(nstype), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
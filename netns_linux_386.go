@@ -0,0 +1,4 @@
+package proxyproto
+
+// sysSetns is missing from syscall on 386.
+const sysSetns = 346
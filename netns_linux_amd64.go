@@ -0,0 +1,4 @@
+package proxyproto
+
+// sysSetns is missing from syscall on amd64.
+const sysSetns = 308
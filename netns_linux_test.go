@@ -0,0 +1,56 @@
+package proxyproto
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+	"testing"
+)
+
+func TestDialNetNS(t *testing.T) {
+	// The namespace of the test itself stands in for a named namespace.
+	defer func(dir string) { netnsDir = dir }(netnsDir)
+	netnsDir = "/proc/self/ns"
+
+	ln, err := ListenNetNS("net", "tcp", "127.0.0.1:0")
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("setns requires CAP_SYS_ADMIN:", err)
+	}
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Write([]byte("ping"))
+			conn.Close()
+		}
+	}()
+
+	hdr := &Header{}
+	hdr.SetNetNS("net")
+	conn, err := hdr.DialNetNS("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer conn.Close()
+	b := make([]byte, 4)
+	if _, err := conn.Read(b); err != nil || string(b) != "ping" {
+		t.Errorf("expected ping, actual %q (%v)", b, err)
+	}
+}
+
+func TestDialNetNS_Invalid(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "../foo"} {
+		if _, err := DialNetNS(name, "tcp", "127.0.0.1:1"); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+
+	if _, err := DialNetNS("proxyproto-missing", "tcp", "127.0.0.1:1"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected %v, actual %v", fs.ErrNotExist, err)
+	}
+	if _, err := (&Header{}).DialNetNS("tcp", "127.0.0.1:1"); err == nil {
+		t.Error("expected an error without NETNS TLV")
+	}
+}
@@ -0,0 +1,104 @@
+package proxyproto
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Observer is notified of the connections of a Listener, e.g. to collect
+// metrics. Its methods may be called concurrently from multiple goroutines
+// and should not block.
+//
+// conn is the underlying connection, not the *Conn: calling the methods of
+// *Conn from an Observer would block on reading the header. Methods reporting
+// on the header also receive the time taken to read it. They are called once
+// the header is read, i.e. on the first call to Read, RemoteAddr, ProxyHeader
+// and the like, unless the context of Listener.AcceptContext is done first.
+type Observer interface {
+	// Accepted is called when a connection is accepted, before its header is read.
+	Accepted(conn net.Conn)
+
+	// Rejected is called when SourceCheck rejects a connection with err.
+	Rejected(conn net.Conn, err error)
+
+	// HeaderParsed is called when a header is received. For LOCAL headers,
+	// whose addresses must be ignored, hdr only has Version and Command set.
+	HeaderParsed(conn net.Conn, hdr *Header, elapsed time.Duration)
+
+	// NoHeader is called when the connection doesn't start with a header.
+	NoHeader(conn net.Conn, elapsed time.Duration)
+
+	// ParseError is called when reading the header fails with err.
+	ParseError(conn net.Conn, err error, elapsed time.Duration)
+
+	// HeaderTimeout is called when ProxyHeaderTimeout expires before the
+	// header starts to arrive, whatever the HeaderTimeoutPolicy.
+	HeaderTimeout(conn net.Conn, elapsed time.Duration)
+}
+
+// ExpvarObserver is an Observer counting connections in an expvar.Map with
+// the following keys:
+//
+//	accepted             connections accepted
+//	rejected             connections rejected by SourceCheck
+//	headers              headers received
+//	header_types         headers received by command, version and protocol, e.g. "PROXY v2 TCPv4"
+//	no_header            connections without a header
+//	parse_errors         headers which failed to be read
+//	header_timeouts      connections timed out before the header
+//	header_read_seconds  total time spent reading headers
+type ExpvarObserver struct {
+	m     *expvar.Map
+	types *expvar.Map
+}
+
+// NewExpvarObserver returns an ExpvarObserver publishing its counters under
+// name. Like expvar.Publish, it panics if name is already in use.
+func NewExpvarObserver(name string) *ExpvarObserver {
+	o := &ExpvarObserver{
+		m:     expvar.NewMap(name),
+		types: new(expvar.Map).Init(),
+	}
+	o.m.Set("header_types", o.types)
+	return o
+}
+
+// Map returns the map holding the counters.
+func (o *ExpvarObserver) Map() *expvar.Map {
+	return o.m
+}
+
+func (o *ExpvarObserver) Accepted(net.Conn) {
+	o.m.Add("accepted", 1)
+}
+
+func (o *ExpvarObserver) Rejected(net.Conn, error) {
+	o.m.Add("rejected", 1)
+}
+
+func (o *ExpvarObserver) HeaderParsed(_ net.Conn, hdr *Header, elapsed time.Duration) {
+	o.m.Add("headers", 1)
+	typ := fmt.Sprintf("%s v%d", hdr.command(), hdr.Version)
+	if !hdr.Command.IsLocal() {
+		typ += " " + hdr.TransportProtocol.String()
+	}
+	o.types.Add(typ, 1)
+	o.m.AddFloat("header_read_seconds", elapsed.Seconds())
+}
+
+func (o *ExpvarObserver) NoHeader(_ net.Conn, elapsed time.Duration) {
+	o.m.Add("no_header", 1)
+	o.m.AddFloat("header_read_seconds", elapsed.Seconds())
+}
+
+func (o *ExpvarObserver) ParseError(_ net.Conn, _ error, elapsed time.Duration) {
+	o.m.Add("parse_errors", 1)
+	o.m.AddFloat("header_read_seconds", elapsed.Seconds())
+}
+
+func (o *ExpvarObserver) HeaderTimeout(_ net.Conn, elapsed time.Duration) {
+	o.m.Add("header_timeouts", 1)
+	o.m.AddFloat("header_read_seconds", elapsed.Seconds())
+}
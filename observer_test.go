@@ -0,0 +1,231 @@
+package proxyproto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingObserver records the names of the events it observes.
+type recordingObserver struct {
+	mu     sync.Mutex
+	events []string
+	header *Header
+	err    error
+}
+
+func (o *recordingObserver) record(event string, hdr *Header, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event)
+	if hdr != nil {
+		o.header = hdr
+	}
+	if err != nil {
+		o.err = err
+	}
+}
+
+func (o *recordingObserver) Accepted(net.Conn) { o.record("accepted", nil, nil) }
+
+func (o *recordingObserver) Rejected(_ net.Conn, err error) { o.record("rejected", nil, err) }
+
+func (o *recordingObserver) HeaderParsed(_ net.Conn, hdr *Header, _ time.Duration) {
+	o.record("header", hdr, nil)
+}
+
+func (o *recordingObserver) NoHeader(net.Conn, time.Duration) { o.record("no header", nil, nil) }
+
+func (o *recordingObserver) ParseError(_ net.Conn, err error, _ time.Duration) {
+	o.record("parse error", nil, err)
+}
+
+func (o *recordingObserver) HeaderTimeout(net.Conn, time.Duration) { o.record("timeout", nil, nil) }
+
+func TestListener_Observer(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		send           []byte
+		delay          time.Duration
+		sourceCheck    SourceChecker
+		expectedEvents []string
+		expectedHeader *Header
+		expectedError  error
+	}{
+		{
+			name:           "v1",
+			send:           []byte("PROXY TCP4 10.1.1.1 20.2.2.2 1000 2000\r\nping"),
+			expectedEvents: []string{"accepted", "header"},
+			expectedHeader: &Header{Version: 1, Command: PROXY, TransportProtocol: TCPv4, SrcAddr: net.ParseIP("10.1.1.1"), DstAddr: net.ParseIP("20.2.2.2"), SrcPort: 1000, DstPort: 2000},
+		},
+		{
+			name:           "LOCAL",
+			send:           catBytes(SIGV2, localBytes, unspecBytes, fixedEmptyLen[:], []byte("ping")),
+			expectedEvents: []string{"accepted", "header"},
+			expectedHeader: &Header{Version: 2, Command: LOCAL},
+		},
+		{
+			name:           "no header",
+			send:           []byte("ping"),
+			expectedEvents: []string{"accepted", "no header"},
+		},
+		{
+			name:           "parse error",
+			send:           []byte("PROXY \r\nping"),
+			expectedEvents: []string{"accepted", "parse error"},
+			expectedError:  ErrCantReadProtocolVersionAndCommand,
+		},
+		{
+			name:           "timeout",
+			send:           []byte("ping"),
+			delay:          200 * time.Millisecond,
+			expectedEvents: []string{"accepted", "timeout"},
+		},
+		{
+			name: "rejected",
+			send: []byte("ping"),
+			sourceCheck: func(addr net.Addr) (bool, error) {
+				return false, ErrInvalidUpstream
+			},
+			expectedEvents: []string{"rejected"},
+			expectedError:  ErrInvalidUpstream,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewTestServer(t, 50*time.Millisecond)
+			o := &recordingObserver{}
+			s.pl.Observer = o
+			s.pl.SourceCheck = tt.sourceCheck
+
+			go func() {
+				conn := s.MustClientConn()
+				defer conn.Close()
+				time.Sleep(tt.delay)
+				conn.Write(tt.send)
+				s.WaitConnClosed(conn)
+			}()
+
+			if tt.sourceCheck != nil {
+				// Accept keeps waiting after the rejection
+				go s.pl.Accept()
+				waitFor(t, func() bool {
+					o.mu.Lock()
+					defer o.mu.Unlock()
+					return len(o.events) > 0
+				})
+				s.ln.Close()
+			} else {
+				conn, err := s.pl.Accept()
+				if err != nil {
+					t.Fatal("unexpected error:", err)
+				}
+				conn.(*Conn).ProxyHeader()
+				conn.Close()
+			}
+
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			if len(o.events) != len(tt.expectedEvents) {
+				t.Fatalf("expected %v, got %v", tt.expectedEvents, o.events)
+			}
+			for i := range o.events {
+				if o.events[i] != tt.expectedEvents[i] {
+					t.Fatalf("expected %v, got %v", tt.expectedEvents, o.events)
+				}
+			}
+			if !errors.Is(o.err, tt.expectedError) {
+				t.Errorf("expected '%v', got '%v'", tt.expectedError, o.err)
+			}
+			if tt.expectedHeader != nil {
+				if o.header.String() != tt.expectedHeader.String() || !assertHeader(o.header, tt.expectedHeader) {
+					t.Errorf("expected %v, got %v", tt.expectedHeader, o.header)
+				}
+			}
+		})
+	}
+}
+
+func TestListener_ObserverCancelled(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		delay time.Duration
+	}{
+		{name: "while reading the header", delay: 50 * time.Millisecond},
+		{name: "before reading the header"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewTestServer(t, 0)
+			defer s.ln.Close()
+			o := &recordingObserver{}
+			s.pl.Observer = o
+
+			go func() {
+				conn := s.MustClientConn()
+				defer conn.Close()
+				s.WaitConnClosed(conn)
+			}()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			conn, err := s.pl.AcceptContext(ctx)
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			defer conn.Close()
+
+			if tt.delay > 0 {
+				time.AfterFunc(tt.delay, cancel)
+			} else {
+				cancel()
+			}
+			if _, err := conn.Read(make([]byte, 1)); err != context.Canceled {
+				t.Fatalf("expected '%v', got '%v'", context.Canceled, err)
+			}
+
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			if len(o.events) != 1 || o.events[0] != "accepted" {
+				t.Errorf("expected [accepted], got %v", o.events)
+			}
+		})
+	}
+}
+
+func TestExpvarObserver(t *testing.T) {
+	// expvar names can't be reused, e.g. with -count
+	o := NewExpvarObserver(fmt.Sprintf("proxyproto_test_%d", time.Now().UnixNano()))
+	o.Accepted(nil)
+	o.Accepted(nil)
+	o.Rejected(nil, ErrInvalidUpstream)
+	o.HeaderParsed(nil, &Header{Version: 2, Command: PROXY, TransportProtocol: TCPv4, SrcAddr: v4addr, DstAddr: v4addr}, time.Second)
+	o.HeaderParsed(nil, &Header{Version: 2, Command: LOCAL}, time.Second)
+	o.HeaderParsed(nil, &Header{Version: 1, TransportProtocol: TCPv4, SrcAddr: v4addr, DstAddr: v4addr}, time.Second)
+	o.NoHeader(nil, time.Second)
+	o.ParseError(nil, ErrInvalidLength, time.Second)
+	o.HeaderTimeout(nil, time.Second)
+
+	var actual map[string]interface{}
+	if err := json.Unmarshal([]byte(o.Map().String()), &actual); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expected := map[string]interface{}{
+		"accepted":            2.0,
+		"rejected":            1.0,
+		"headers":             3.0,
+		"header_types":        map[string]interface{}{"PROXY v2 TCPv4": 1.0, "LOCAL v2": 1.0, "PROXY v1 TCPv4": 1.0},
+		"no_header":           1.0,
+		"parse_errors":        1.0,
+		"header_timeouts":     1.0,
+		"header_read_seconds": 6.0,
+	}
+	actualJSON, _ := json.Marshal(actual)
+	expectedJSON, _ := json.Marshal(expected)
+	if string(actualJSON) != string(expectedJSON) {
+		t.Errorf("expected %s, got %s", expectedJSON, actualJSON)
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"strings"
 )
 
 var (
@@ -26,8 +29,45 @@ var (
 	ErrInvalidLength                        = errors.New("proxyproto: invalid length")
 	ErrInvalidAddress                       = errors.New("proxyproto: invalid address")
 	ErrInvalidPortNumber                    = errors.New("proxyproto: invalid port number")
+	ErrHeaderTooLarge                       = errors.New("proxyproto: header exceeds the maximum size")
 )
 
+// ParseError describes where and why a proxy protocol header could not be parsed.
+// It wraps one of the sentinel errors above so errors.Is keeps working.
+type ParseError struct {
+	// Version is the proxy protocol version of the header being parsed.
+	Version int
+
+	// Offset is the byte offset of the offending field from the start of the header.
+	Offset int
+
+	// Field is the name of the offending field.
+	Field string
+
+	// Bytes holds the offending bytes, if any were read.
+	Bytes []byte
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	var b string
+	switch {
+	case len(e.Bytes) == 0:
+		b = "<none>"
+	case e.Version == 1:
+		b = fmt.Sprintf("%q", e.Bytes)
+	default:
+		b = fmt.Sprintf("% x", e.Bytes)
+	}
+	return fmt.Sprintf("%v (v%d %s at offset %d: %s)", e.Err, e.Version, e.Field, e.Offset, b)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 // ProtocolVersionAndCommand represents proxy protocol version and command.
 type ProtocolVersionAndCommand byte
 
@@ -61,6 +101,17 @@ func (pvc ProtocolVersionAndCommand) IsUnspec() bool {
 	return !(pvc.IsLocal() || pvc.IsProxy())
 }
 
+// String returns "LOCAL" or "PROXY", or the byte in hex if the command is unspecified.
+func (pvc ProtocolVersionAndCommand) String() string {
+	switch {
+	case pvc.IsLocal():
+		return "LOCAL"
+	case pvc.IsProxy():
+		return "PROXY"
+	}
+	return fmt.Sprintf("0x%02x", byte(pvc))
+}
+
 // AddressFamilyAndProtocol represents address family and transport protocol.
 type AddressFamilyAndProtocol byte
 
@@ -105,6 +156,24 @@ func (ap AddressFamilyAndProtocol) IsUnspec() bool {
 	return (0x00 == ap&0xF0) || (0x00 == ap&0x0F)
 }
 
+// String returns the name of the address family and protocol, e.g. "TCPv4",
+// or the byte in hex if it is not supported.
+func (ap AddressFamilyAndProtocol) String() string {
+	switch ap {
+	case UNSPEC:
+		return "UNSPEC"
+	case TCPv4:
+		return "TCPv4"
+	case UDPv4:
+		return "UDPv4"
+	case TCPv6:
+		return "TCPv6"
+	case UDPv6:
+		return "UDPv6"
+	}
+	return fmt.Sprintf("0x%02x", byte(ap))
+}
+
 func validateLeastAddressLen(ap AddressFamilyAndProtocol, len uint16) bool {
 	switch {
 	case ap.IsIPv4():
@@ -131,6 +200,15 @@ type Header struct {
 	// v2 specific
 	Command           ProtocolVersionAndCommand
 	TransportProtocol AddressFamilyAndProtocol
+	TLVs              []TLV
+
+	// Padding on write, v2 specific. If PadSize is set, the header is padded
+	// with NOOP TLVs to PadSize bytes in total. Otherwise, if PadAlign is set,
+	// it is padded to a multiple of PadAlign bytes. They are settings of
+	// WriteTo rather than part of the header: Read skips NOOP TLVs and leaves
+	// them unset, and MarshalJSON and MarshalText leave them out.
+	PadSize  int
+	PadAlign int
 }
 
 func (h *Header) addr(addr net.IP, port uint16) net.Addr {
@@ -158,6 +236,74 @@ func (h *Header) LocalAddr() net.Addr {
 	return h.addr(h.DstAddr, h.DstPort)
 }
 
+// hasAddrs reports whether the header carries IP addresses and ports.
+func (h *Header) hasAddrs() bool {
+	return (h.TransportProtocol.IsIPv4() || h.TransportProtocol.IsIPv6()) && !h.TransportProtocol.IsUnspec()
+}
+
+// command returns the command of the header. v1 has no command, every header
+// is PROXY, so Command is left unset when reading v1 headers.
+func (h *Header) command() ProtocolVersionAndCommand {
+	if h.Version == 1 {
+		return PROXY
+	}
+	return h.Command
+}
+
+// String returns a human-readable form of the header suitable for logging, e.g.
+//
+//	PROXY v2 TCPv4 127.0.0.1:56324 -> 127.0.0.2:443 [ALPN="h2"]
+func (h *Header) String() string {
+	if h == nil {
+		return "<nil>"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s v%d %s", h.command(), h.Version, h.TransportProtocol)
+	if h.hasAddrs() {
+		fmt.Fprintf(&b, " %s -> %s", h.RemoteAddr(), h.LocalAddr())
+	}
+	if len(h.TLVs) > 0 {
+		b.WriteString(" [")
+		for i, tlv := range h.TLVs {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(tlv.String())
+		}
+		b.WriteByte(']')
+	}
+	return b.String()
+}
+
+// LogValue implements slog.LogValuer so that a header is logged as a group of
+// structured fields rather than as raw bytes.
+func (h *Header) LogValue() slog.Value {
+	if h == nil {
+		return slog.Value{}
+	}
+
+	attrs := []slog.Attr{
+		slog.Int("version", h.Version),
+		slog.String("command", h.command().String()),
+		slog.String("protocol", h.TransportProtocol.String()),
+	}
+	if h.hasAddrs() {
+		attrs = append(attrs,
+			slog.String("src", h.RemoteAddr().String()),
+			slog.String("dst", h.LocalAddr().String()),
+		)
+	}
+	if len(h.TLVs) > 0 {
+		tlvs := make([]string, len(h.TLVs))
+		for i, tlv := range h.TLVs {
+			tlvs[i] = tlv.String()
+		}
+		attrs = append(attrs, slog.Any("tlvs", tlvs))
+	}
+	return slog.GroupValue(attrs...)
+}
+
 // WriteTo renders a proxy protocol header in a format to write over the wire.
 func (h *Header) WriteTo(w io.Writer) (int64, error) {
 	switch h.Version {
@@ -174,15 +320,24 @@ func (h *Header) WriteTo(w io.Writer) (int64, error) {
 // the header, accordingly.
 //
 // If proxy protocol header signature is not present, the reader buffer remains untouched
-// and is safe for reading outside of this code.
+// and is safe for reading outside of this code. ErrNoProxyProtocol is returned, wrapping
+// the error if peeking the signature failed for another reason than EOF (e.g. a timeout).
 //
 // If proxy protocol header signature is present but an error is raised while processing
 // the remaining header, assume the reader buffer to be in a corrupt state.
 // Also, this operation will block until enough bytes are available for peeking.
+//
+// Version 2 headers are read in full regardless of the size of the buffer of br.
 func Read(br *bufio.Reader) (*Header, error) {
+	return read(br, 0)
+}
+
+// read is Read rejecting version 2 headers larger than maxHeaderSize bytes
+// with ErrHeaderTooLarge if maxHeaderSize is positive.
+func read(br *bufio.Reader, maxHeaderSize int) (*Header, error) {
 	b1, err := br.Peek(1)
 	if err != nil {
-		return nil, ErrNoProxyProtocol
+		return nil, noProxyProtocol(err)
 	}
 
 	// In order to improve speed for small non-PROXYed packets, take a peek at the first byte alone.
@@ -192,7 +347,7 @@ func Read(br *bufio.Reader) (*Header, error) {
 
 	v1Peek, err := br.Peek(5)
 	if err != nil {
-		return nil, ErrNoProxyProtocol
+		return nil, noProxyProtocol(err)
 	}
 	if bytes.Equal(v1Peek[:5], SIGV1) {
 		return parseVersion1(br)
@@ -200,11 +355,19 @@ func Read(br *bufio.Reader) (*Header, error) {
 
 	v2Sig, err := br.Peek(12)
 	if err != nil {
-		return nil, ErrNoProxyProtocol
+		return nil, noProxyProtocol(err)
 	}
 	if bytes.Equal(v2Sig[:12], SIGV2) {
-		return parseVersion2(br)
+		return parseVersion2(br, maxHeaderSize)
 	}
 
 	return nil, ErrNoProxyProtocol
 }
+
+// noProxyProtocol returns ErrNoProxyProtocol wrapping err unless err is just EOF.
+func noProxyProtocol(err error) error {
+	if err == io.EOF {
+		return ErrNoProxyProtocol
+	}
+	return fmt.Errorf("%w: %w", ErrNoProxyProtocol, err)
+}
@@ -1,7 +1,13 @@
 package proxyproto
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
 	"net"
+	"strings"
 	"testing"
 )
 
@@ -221,3 +227,488 @@ func TestHeader_Addr(t *testing.T) {
 		})
 	}
 }
+
+func TestHeader_String(t *testing.T) {
+	for _, tt := range []struct {
+		header   *Header
+		expected string
+	}{
+		{
+			header: &Header{
+				Version:           2,
+				Command:           PROXY,
+				TransportProtocol: TCPv4,
+				SrcAddr:           net.ParseIP("127.0.0.1"),
+				DstAddr:           net.ParseIP("127.0.0.2"),
+				SrcPort:           56324,
+				DstPort:           443,
+				TLVs: []TLV{
+					{Type: PP2_TYPE_ALPN, Value: []byte("h2")},
+					{Type: PP2_TYPE_SSL, Value: []byte{0x01, 0x00}},
+					{Type: 0xE5, Value: nil},
+				},
+			},
+			expected: `PROXY v2 TCPv4 127.0.0.1:56324 -> 127.0.0.2:443 [ALPN="h2" SSL(2 bytes) 0xe5=""]`,
+		},
+		{
+			header: &Header{
+				Version:           1,
+				Command:           PROXY,
+				TransportProtocol: TCPv6,
+				SrcAddr:           net.ParseIP("2001:db8::1"),
+				DstAddr:           net.ParseIP("2001:db8::2"),
+				SrcPort:           56324,
+				DstPort:           443,
+			},
+			expected: "PROXY v1 TCPv6 [2001:db8::1]:56324 -> [2001:db8::2]:443",
+		},
+		{
+			header: &Header{
+				Version:           2,
+				Command:           LOCAL,
+				TransportProtocol: UNSPEC,
+			},
+			expected: "LOCAL v2 UNSPEC",
+		},
+		{
+			header: &Header{
+				Version:           2,
+				Command:           0x2F,
+				TransportProtocol: 0x31,
+			},
+			expected: "0x2f v2 0x31",
+		},
+		{
+			header:   nil,
+			expected: "<nil>",
+		},
+	} {
+		if actual := tt.header.String(); actual != tt.expected {
+			t.Errorf("expected %q, actual %q", tt.expected, actual)
+		}
+	}
+}
+
+func TestHeader_LogValue(t *testing.T) {
+	hdr := &Header{
+		Version:           2,
+		Command:           PROXY,
+		TransportProtocol: TCPv4,
+		SrcAddr:           net.ParseIP("127.0.0.1"),
+		DstAddr:           net.ParseIP("127.0.0.2"),
+		SrcPort:           56324,
+		DstPort:           443,
+		TLVs: []TLV{
+			{Type: PP2_TYPE_AUTHORITY, Value: []byte("example.com")},
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	logger.Info("accepted", "proxy", hdr)
+
+	expected := `level=INFO msg=accepted proxy.version=2 proxy.command=PROXY proxy.protocol=TCPv4 proxy.src=127.0.0.1:56324 proxy.dst=127.0.0.2:443 proxy.tlvs="[AUTHORITY=\"example.com\"]"`
+	if actual := strings.TrimSpace(buf.String()); actual != expected {
+		t.Errorf("expected\n%s\nactual\n%s", expected, actual)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestReadNoProxyProtocolCause(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		r       io.Reader
+		timeout bool
+	}{
+		{
+			name: "EOF",
+			r:    bytes.NewReader([]byte("PROX")),
+		},
+		{
+			name:    "timeout",
+			r:       io.MultiReader(bytes.NewReader([]byte("PROX")), &errReader{timeoutError{}}),
+			timeout: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(bufio.NewReader(tt.r))
+			if !errors.Is(err, ErrNoProxyProtocol) {
+				t.Fatalf("expected '%v', got '%v'", ErrNoProxyProtocol, err)
+			}
+			if isTimeout(err) != tt.timeout {
+				t.Fatalf("expected timeout to be %v, got '%v'", tt.timeout, err)
+			}
+		})
+	}
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func TestParseError(t *testing.T) {
+	for _, tt := range []struct {
+		bytes    []byte
+		expected ParseError
+	}{
+		{
+			[]byte("PROXY TCP4 " + IP4_ADDR + " 1.2.3 1 2" + CRLF),
+			ParseError{
+				Version: 1,
+				Offset:  len("PROXY TCP4 " + IP4_ADDR + " "),
+				Field:   "destination address",
+				Bytes:   []byte("1.2.3"),
+				Err:     ErrInvalidAddress,
+			},
+		},
+		{
+			[]byte("PROXY TCP4 " + IP4_ADDR + " " + IP4_ADDR + " 1 http" + CRLF),
+			ParseError{
+				Version: 1,
+				Offset:  len("PROXY TCP4 " + IP4_ADDR + " " + IP4_ADDR + " 1 "),
+				Field:   "destination port",
+				Bytes:   []byte("http"),
+				Err:     ErrInvalidPortNumber,
+			},
+		},
+		{
+			catBytes(SIGV2, proxyBytes, invalidBytes),
+			ParseError{
+				Version: 2,
+				Offset:  13,
+				Field:   "address family and protocol",
+				Bytes:   invalidBytes,
+				Err:     ErrUnsupportedAddressFamilyAndProtocol,
+			},
+		},
+		{
+			catBytes(SIGV2, proxyBytes, tcpv6Bytes, fixedV4AddrLen[:], fixtureIPv4Address),
+			ParseError{
+				Version: 2,
+				Offset:  14,
+				Field:   "length",
+				Bytes:   fixedV4AddrLen[:],
+				Err:     ErrInvalidLength,
+			},
+		},
+	} {
+		t.Run(tt.expected.Field, func(t *testing.T) {
+			_, err := Read(newBufioReader(tt.bytes))
+
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected *ParseError, got %#v", err)
+			}
+			if !errors.Is(err, tt.expected.Err) {
+				t.Errorf("expected errors.Is(err, %v) to be true", tt.expected.Err)
+			}
+			if perr.Version != tt.expected.Version ||
+				perr.Offset != tt.expected.Offset ||
+				perr.Field != tt.expected.Field ||
+				!bytes.Equal(perr.Bytes, tt.expected.Bytes) {
+				t.Errorf("expected %#v, got %#v", tt.expected, *perr)
+			}
+			t.Log(err)
+		})
+	}
+}
+
+// conformanceCorpus holds the examples from the specification along with the
+// outcome expected from Read. It also seeds the fuzz targets.
+var conformanceCorpus = []struct {
+	name           string
+	bytes          []byte
+	expectedHeader *Header
+	expectedError  error
+}{
+	{
+		name:  "v1 TCP4 maximum",
+		bytes: []byte("PROXY TCP4 255.255.255.255 255.255.255.255 65535 65535\r\n"),
+		expectedHeader: &Header{
+			Version:           1,
+			TransportProtocol: TCPv4,
+			SrcAddr:           net.ParseIP("255.255.255.255"),
+			DstAddr:           net.ParseIP("255.255.255.255"),
+			SrcPort:           65535,
+			DstPort:           65535,
+		},
+	},
+	{
+		name:  "v1 TCP6 maximum",
+		bytes: []byte("PROXY TCP6 ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff 65535 65535\r\n"),
+		expectedHeader: &Header{
+			Version:           1,
+			TransportProtocol: TCPv6,
+			SrcAddr:           net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"),
+			DstAddr:           net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"),
+			SrcPort:           65535,
+			DstPort:           65535,
+		},
+	},
+	{
+		name:  "v1 UNKNOWN",
+		bytes: []byte("PROXY UNKNOWN\r\n"),
+		expectedHeader: &Header{
+			Version:           1,
+			TransportProtocol: UNSPEC,
+		},
+	},
+	{
+		name:  "v1 UNKNOWN with addresses",
+		bytes: []byte("PROXY UNKNOWN ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff 65535 65535\r\n"),
+		expectedHeader: &Header{
+			Version:           1,
+			TransportProtocol: UNSPEC,
+		},
+	},
+	{
+		name:  "v1 TCP4",
+		bytes: []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET / HTTP/1.1\r\nHost: 192.168.0.11\r\n\r\n"),
+		expectedHeader: &Header{
+			Version:           1,
+			TransportProtocol: TCPv4,
+			SrcAddr:           net.ParseIP("192.168.0.1"),
+			DstAddr:           net.ParseIP("192.168.0.11"),
+			SrcPort:           56324,
+			DstPort:           443,
+		},
+	},
+	{
+		name:          "v1 missing CRLF",
+		bytes:         []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\n"),
+		expectedError: ErrCantReadProtocolVersionAndCommand,
+	},
+	{
+		name:          "v1 missing ports",
+		bytes:         []byte("PROXY TCP4 192.168.0.1 192.168.0.11\r\n"),
+		expectedError: ErrCantReadProtocolVersionAndCommand,
+	},
+	{
+		name:          "v1 port out of range",
+		bytes:         []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 65536\r\n"),
+		expectedError: ErrInvalidPortNumber,
+	},
+	{
+		name:  "v2 PROXY TCP4",
+		bytes: catBytes(SIGV2, proxyBytes, tcpv4Bytes, fixtureIPv4V2),
+		expectedHeader: &Header{
+			Version:           2,
+			Command:           PROXY,
+			TransportProtocol: TCPv4,
+			SrcAddr:           v4addr,
+			DstAddr:           v4addr,
+			SrcPort:           PORT,
+			DstPort:           PORT,
+		},
+	},
+	{
+		name:  "v2 PROXY UDP6",
+		bytes: catBytes(SIGV2, proxyBytes, udpv6Bytes, fixtureIPv6V2),
+		expectedHeader: &Header{
+			Version:           2,
+			Command:           PROXY,
+			TransportProtocol: UDPv6,
+			SrcAddr:           v6addr,
+			DstAddr:           v6addr,
+			SrcPort:           PORT,
+			DstPort:           PORT,
+		},
+	},
+	{
+		name:  "v2 PROXY UNSPEC",
+		bytes: catBytes(SIGV2, proxyBytes, unspecBytes, fixedEmptyLen[:]),
+		expectedHeader: &Header{
+			Version:           2,
+			Command:           PROXY,
+			TransportProtocol: UNSPEC,
+		},
+	},
+	{
+		name:  "v2 LOCAL",
+		bytes: catBytes(SIGV2, localBytes, unspecBytes, fixedEmptyLen[:]),
+	},
+	{
+		name:  "v2 PROXY TCP6 padded",
+		bytes: catBytes(SIGV2, proxyBytes, tcpv6Bytes, fixtureIPv6V2Padded),
+		expectedHeader: &Header{
+			Version:           2,
+			Command:           PROXY,
+			TransportProtocol: TCPv6,
+			SrcAddr:           v6addr,
+			DstAddr:           v6addr,
+			SrcPort:           PORT,
+			DstPort:           PORT,
+		},
+	},
+	{
+		name:  "v2 PROXY TCP4 with TLVs",
+		bytes: catBytes(SIGV2, proxyBytes, tcpv4Bytes, []byte{0, byte(v4AddrLen + len(fixtureTLVBytes))}, fixtureIPv4Address, fixtureTLVBytes),
+		expectedHeader: &Header{
+			Version:           2,
+			Command:           PROXY,
+			TransportProtocol: TCPv4,
+			SrcAddr:           v4addr,
+			DstAddr:           v4addr,
+			SrcPort:           PORT,
+			DstPort:           PORT,
+			TLVs:              fixtureTLVs,
+		},
+	},
+	{
+		name:          "v2 version 1 command",
+		bytes:         catBytes(SIGV2, []byte{'\x11'}, tcpv4Bytes, fixtureIPv4V2),
+		expectedError: ErrUnsupportedProtocolVersionAndCommand,
+	},
+	{
+		name:          "v2 truncated addresses",
+		bytes:         catBytes(SIGV2, proxyBytes, tcpv4Bytes, fixedV4AddrLen[:], addressesIPv4),
+		expectedError: ErrInvalidLength,
+	},
+	{
+		name:          "not PROXY",
+		bytes:         []byte("GET / HTTP/1.1\r\n\r\n"),
+		expectedError: ErrNoProxyProtocol,
+	},
+}
+
+func TestReadConformance(t *testing.T) {
+	for _, tt := range conformanceCorpus {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := Read(newBufioReader(tt.bytes))
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("expected '%v', actual '%v'", tt.expectedError, err)
+			}
+			if !assertHeader(actual, tt.expectedHeader) {
+				t.Fatalf("expected %#v, actual %#v", tt.expectedHeader, actual)
+			}
+			// v1 headers have no command
+			if actual != nil && (actual.Version != tt.expectedHeader.Version || actual.Command != tt.expectedHeader.Command) {
+				t.Fatalf("expected %#v, actual %#v", tt.expectedHeader, actual)
+			}
+		})
+	}
+}
+
+func FuzzRead(f *testing.F) {
+	for _, tt := range conformanceCorpus {
+		f.Add(tt.bytes)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		hdr, err := Read(newBufioReader(b))
+		if err != nil {
+			var perr *ParseError
+			if !errors.Is(err, ErrNoProxyProtocol) && !errors.As(err, &perr) {
+				t.Fatalf("unexpected error type: %#v", err)
+			}
+			return
+		}
+		if hdr != nil {
+			assertRoundTrip(t, hdr)
+		}
+	})
+}
+
+func FuzzHeaderRoundTrip(f *testing.F) {
+	f.Add(uint8(1), uint8(PROXY), uint8(TCPv4), []byte(v4addr), []byte(v4addr), uint16(PORT), uint16(PORT))
+	f.Add(uint8(1), uint8(PROXY), uint8(TCPv6), []byte(v6addr), []byte(v6addr), uint16(0), uint16(65535))
+	f.Add(uint8(2), uint8(PROXY), uint8(UDPv4), []byte(v4addr), []byte(v4addr), uint16(PORT), uint16(PORT))
+	f.Add(uint8(2), uint8(PROXY), uint8(UNSPEC), []byte{}, []byte{}, uint16(0), uint16(0))
+
+	f.Fuzz(func(t *testing.T, version, command, proto uint8, src, dst []byte, srcPort, dstPort uint16) {
+		hdr := &Header{
+			Version:           2 - int(version%2),
+			Command:           PROXY,
+			TransportProtocol: AddressFamilyAndProtocol(proto),
+			SrcPort:           srcPort,
+			DstPort:           dstPort,
+		}
+
+		// Only generate headers which are representable in the given version
+		switch hdr.Version {
+		case 1:
+			switch hdr.TransportProtocol {
+			case TCPv4, TCPv6, UNSPEC:
+			default:
+				t.Skip()
+			}
+		case 2:
+			if !isSupportedTransportProtocol(hdr.TransportProtocol) {
+				t.Skip()
+			}
+			if command%2 == 0 {
+				hdr.Command = LOCAL
+			}
+		}
+
+		switch {
+		case hdr.TransportProtocol.IsIPv4():
+			hdr.SrcAddr = fuzzIP(src, net.IPv4len)
+			hdr.DstAddr = fuzzIP(dst, net.IPv4len)
+		case hdr.TransportProtocol.IsIPv6():
+			hdr.SrcAddr = fuzzIP(src, net.IPv6len)
+			hdr.DstAddr = fuzzIP(dst, net.IPv6len)
+			if hdr.Version == 1 && (hdr.SrcAddr.To4() != nil || hdr.DstAddr.To4() != nil) {
+				// IPv4-mapped addresses are rendered in dotted notation in v1
+				t.Skip()
+			}
+		default:
+			hdr.SrcPort = 0
+			hdr.DstPort = 0
+		}
+
+		assertRoundTrip(t, hdr)
+	})
+}
+
+// fuzzIP returns a n-byte IP address built from b.
+func fuzzIP(b []byte, n int) net.IP {
+	ip := make(net.IP, n)
+	copy(ip, b)
+	return ip
+}
+
+// assertRoundTrip asserts that hdr is read back unchanged after being written.
+func assertRoundTrip(t *testing.T, hdr *Header) {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	if _, err := hdr.WriteTo(buf); err != nil {
+		t.Fatalf("failed to write %#v: %v", hdr, err)
+	}
+	written := buf.Bytes()
+
+	br := newBufioReader(written)
+	actual, err := Read(br)
+	if err != nil {
+		t.Fatalf("failed to read %q written from %#v: %v", written, hdr, err)
+	}
+	if rest, _ := br.Peek(1); len(rest) != 0 {
+		t.Fatalf("trailing bytes after the header %q written from %#v", written, hdr)
+	}
+	if hdr.Command.IsLocal() && hdr.Version == 2 {
+		if actual != nil {
+			t.Fatalf("expected nil header for LOCAL, actual %#v", actual)
+		}
+		return
+	}
+	if actual == nil || actual.Version != hdr.Version || !assertHeader(actual, hdr) {
+		t.Fatalf("expected %#v, actual %#v (written %q)", hdr, actual, written)
+	}
+}
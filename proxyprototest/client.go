@@ -0,0 +1,76 @@
+package proxyprototest
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	proxyproto "github.com/nabeken/go-proxyproto"
+)
+
+// Client sends a header at the start of the connections it dials. The
+// faults it injects can be combined: for instance, a header can be
+// truncated and sent byte by byte after a delay.
+type Client struct {
+	// Header is sent first. A nil Header sends no header at all.
+	Header *proxyproto.Header
+
+	// Delay is waited for before sending the header, e.g. to exceed
+	// Listener.ProxyHeaderTimeout.
+	Delay time.Duration
+
+	// FragmentSize, if positive, splits the header into writes of at most
+	// FragmentSize bytes, FragmentDelay apart. Use 1 to send it byte by byte.
+	FragmentSize  int
+	FragmentDelay time.Duration
+
+	// Truncate, if positive, only sends the first Truncate bytes of the header.
+	Truncate int
+}
+
+// Dial connects to address and sends the header.
+func (c *Client) Dial(network, address string) (net.Conn, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.WriteHeader(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// WriteHeader sends the header to conn, injecting the faults of c.
+func (c *Client) WriteHeader(conn net.Conn) error {
+	if c.Header == nil {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	if _, err := c.Header.WriteTo(buf); err != nil {
+		return err
+	}
+	b := buf.Bytes()
+	if c.Truncate > 0 && c.Truncate < len(b) {
+		b = b[:c.Truncate]
+	}
+
+	time.Sleep(c.Delay)
+	if c.FragmentSize <= 0 {
+		_, err := conn.Write(b)
+		return err
+	}
+	for i := 0; i < len(b); i += c.FragmentSize {
+		if i > 0 {
+			time.Sleep(c.FragmentDelay)
+		}
+		end := i + c.FragmentSize
+		if end > len(b) {
+			end = len(b)
+		}
+		if _, err := conn.Write(b[i:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
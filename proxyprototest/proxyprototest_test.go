@@ -0,0 +1,151 @@
+package proxyprototest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	proxyproto "github.com/nabeken/go-proxyproto"
+)
+
+var testHeader = &proxyproto.Header{
+	Version:           2,
+	Command:           proxyproto.PROXY,
+	TransportProtocol: proxyproto.TCPv4,
+	SrcAddr:           net.ParseIP("10.1.1.1").To4(),
+	DstAddr:           net.ParseIP("20.2.2.2").To4(),
+	SrcPort:           1000,
+	DstPort:           2000,
+	TLVs:              []proxyproto.TLV{{Type: proxyproto.PP2_TYPE_ALPN, Value: []byte("h2")}},
+}
+
+type result struct {
+	header  *proxyproto.Header
+	err     error
+	payload string
+}
+
+func TestClient(t *testing.T) {
+	for _, tt := range []struct {
+		name            string
+		client          *Client
+		timeout         time.Duration
+		policy          proxyproto.HeaderTimeoutPolicy
+		expectedHeader  *proxyproto.Header
+		expectedError   error
+		expectedPayload string
+	}{
+		{
+			name:            "header",
+			client:          &Client{Header: testHeader},
+			expectedHeader:  testHeader,
+			expectedPayload: "ping",
+		},
+		{
+			name:            "v1 header",
+			client:          &Client{Header: &proxyproto.Header{Version: 1, Command: proxyproto.PROXY, TransportProtocol: proxyproto.TCPv4, SrcAddr: testHeader.SrcAddr, DstAddr: testHeader.DstAddr, SrcPort: 1000, DstPort: 2000}},
+			expectedHeader:  &proxyproto.Header{Version: 1, Command: proxyproto.PROXY, TransportProtocol: proxyproto.TCPv4, SrcAddr: testHeader.SrcAddr, DstAddr: testHeader.DstAddr, SrcPort: 1000, DstPort: 2000},
+			expectedPayload: "ping",
+		},
+		{
+			name:            "no header",
+			client:          &Client{},
+			expectedPayload: "ping",
+		},
+		{
+			name:            "fragmented",
+			client:          &Client{Header: testHeader, FragmentSize: 1, FragmentDelay: time.Millisecond},
+			expectedHeader:  testHeader,
+			expectedPayload: "ping",
+		},
+		{
+			name:            "delayed",
+			client:          &Client{Header: testHeader, Delay: 100 * time.Millisecond},
+			timeout:         50 * time.Millisecond,
+			expectedPayload: string(mustMarshal(t, testHeader)) + "ping",
+		},
+		{
+			name:          "delayed and rejected",
+			client:        &Client{Header: testHeader, Delay: 100 * time.Millisecond},
+			timeout:       50 * time.Millisecond,
+			policy:        proxyproto.TimeoutReject,
+			expectedError: proxyproto.ErrHeaderTimeout,
+		},
+		{
+			name:          "truncated",
+			client:        &Client{Header: testHeader, Truncate: 20},
+			expectedError: proxyproto.ErrInvalidLength,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			results := make(chan result, 1)
+			s := NewUnstartedServer(func(conn *proxyproto.Conn) {
+				var r result
+				r.header, r.err = conn.ProxyHeader()
+				if r.err == nil {
+					b, _ := io.ReadAll(conn)
+					r.payload = string(b)
+				}
+				results <- r
+			})
+			s.Listener.ProxyHeaderTimeout = tt.timeout
+			s.Listener.HeaderTimeoutPolicy = tt.policy
+			s.Start()
+			defer s.Close()
+
+			conn, err := tt.client.Dial("tcp", s.Addr().String())
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if tt.client.Truncate == 0 {
+				conn.Write([]byte("ping"))
+			}
+			conn.(*net.TCPConn).CloseWrite()
+			defer conn.Close()
+
+			r := <-results
+			if !errors.Is(r.err, tt.expectedError) {
+				t.Fatalf("expected '%v', actual '%v'", tt.expectedError, r.err)
+			}
+			if (r.header == nil) != (tt.expectedHeader == nil) ||
+				r.header != nil && r.header.String() != tt.expectedHeader.String() {
+				t.Errorf("expected %v, actual %v", tt.expectedHeader, r.header)
+			}
+			if r.payload != tt.expectedPayload {
+				t.Errorf("expected %q, actual %q", tt.expectedPayload, r.payload)
+			}
+		})
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	handling := make(chan struct{})
+	s := NewServer(func(conn *proxyproto.Conn) {
+		close(handling)
+		// blocks until Close closes the connection
+		io.ReadAll(conn)
+	})
+
+	conn, err := (&Client{Header: testHeader}).Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer conn.Close()
+	<-handling
+
+	s.Close()
+	if _, err := net.Dial("tcp", s.Addr().String()); err == nil {
+		t.Error("expected the server to stop listening")
+	}
+}
+
+func mustMarshal(t *testing.T, hdr *proxyproto.Header) []byte {
+	buf := &bytes.Buffer{}
+	if _, err := hdr.WriteTo(buf); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,115 @@
+// Package proxyprototest provides utilities to test PROXY protocol handling
+// end to end: a loopback server wrapping proxyproto.Listener, and a client
+// sending headers with optional faults such as fragmentation, delays and
+// truncation.
+package proxyprototest
+
+import (
+	"net"
+	"sync"
+
+	proxyproto "github.com/nabeken/go-proxyproto"
+)
+
+// Server is a server listening on a loopback address, handling each
+// connection accepted by a proxyproto.Listener in its own goroutine.
+type Server struct {
+	// Listener accepts the connections. It may be configured, e.g. with
+	// ProxyHeaderTimeout or SourceCheck, between NewUnstartedServer and Start.
+	Listener *proxyproto.Listener
+
+	handler func(conn *proxyproto.Conn)
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewServer starts and returns a new Server calling handler for each
+// connection. The caller should call Close when finished, to shut it down.
+func NewServer(handler func(conn *proxyproto.Conn)) *Server {
+	s := NewUnstartedServer(handler)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a new Server listening on a loopback address
+// but not accepting connections yet. The caller should call Start once the
+// Listener is configured, and Close when finished.
+func NewUnstartedServer(handler func(conn *proxyproto.Conn)) *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		if ln, err = net.Listen("tcp6", "[::1]:0"); err != nil {
+			panic("proxyprototest: failed to listen on a port: " + err.Error())
+		}
+	}
+	return &Server{
+		Listener: &proxyproto.Listener{Listener: ln},
+		handler:  handler,
+		conns:    map[net.Conn]struct{}{},
+	}
+}
+
+// Start starts accepting connections.
+func (s *Server) Start() {
+	s.wg.Add(1)
+	go s.serve()
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.Listener.Addr()
+}
+
+// Close stops accepting connections, closes the connections being handled
+// and waits for their handlers to return.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.Listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.Listener.Accept()
+		if err != nil {
+			return
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			defer conn.Close()
+			s.handler(conn.(*proxyproto.Conn))
+		}()
+	}
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
@@ -0,0 +1,193 @@
+package proxyproto
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+// Relay forwards connections to a backend, preceding each of them with a
+// PROXY protocol header describing the original client.
+//
+// If the accepted connection is a *Conn, e.g. when serving a *Listener, the
+// header received from a trusted upstream is forwarded so that the real client
+// address is preserved across every hop. Otherwise, or if the upstream did not
+// send a header, the header is derived from the addresses of the connection.
+// A received CRC32C TLV is recomputed for the header sent.
+//
+// The header received is trusted as is: set SourceCheck on the served
+// Listener to only accept it from upstream proxies. Otherwise any client can
+// declare the addresses and TLVs forwarded to the backend.
+type Relay struct {
+	// Backend is the address of the backend to forward connections to.
+	Backend string
+
+	// Dial connects to the backend. If nil, net.Dial is used.
+	Dial func(network, address string) (net.Conn, error)
+
+	// Version is the PROXY protocol version to send. If zero, the version of
+	// the received header is kept, and version 1 is used for derived headers.
+	// Information version 1 cannot represent is dropped, see Header.ConvertTo.
+	Version int
+
+	// TLVs are added to the header sent, replacing received TLVs of the same
+	// type. They require version 2.
+	TLVs []TLV
+
+	// Rewrite, if set, is called with the header about to be sent and may
+	// modify it. Returning an error drops the connection.
+	Rewrite func(hdr *Header) error
+
+	// ErrorLog logs errors relaying connections. If nil, the log package's
+	// standard logger is used.
+	ErrorLog *log.Logger
+}
+
+// Serve accepts connections from ln and relays each of them to the backend
+// in its own goroutine. It returns when ln.Accept returns an error.
+func (r *Relay) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := r.ServeConn(conn); err != nil {
+				r.logf("[ERR] Failed to relay %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// ServeConn relays conn to the backend and returns once both directions are
+// done. conn is closed on return.
+func (r *Relay) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+
+	hdr, err := r.header(conn)
+	if err != nil {
+		return err
+	}
+
+	dial := r.Dial
+	if dial == nil {
+		dial = net.Dial
+	}
+	backend, err := dial("tcp", r.Backend)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	if _, err := hdr.WriteTo(backend); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copyAndCloseWrite(backend, conn)
+	}()
+	go func() {
+		defer wg.Done()
+		copyAndCloseWrite(conn, backend)
+	}()
+	wg.Wait()
+	return nil
+}
+
+// header returns the header to send to the backend for conn.
+func (r *Relay) header(conn net.Conn) (*Header, error) {
+	var received *Header
+	if pc, ok := conn.(*Conn); ok {
+		var err error
+		if received, err = pc.ProxyHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	version := r.Version
+	if received == nil {
+		var err error
+		if received, err = headerFromConn(conn); err != nil {
+			return nil, err
+		}
+		if version == 0 {
+			version = 1
+		}
+	} else if version == 0 {
+		version = received.Version
+	}
+
+	if len(r.TLVs) > 0 && version != 2 {
+		return nil, errors.New("proxyproto: TLVs require version 2")
+	}
+
+	hdr, err := received.ConvertTo(version)
+	if err != nil && !errors.Is(err, ErrLossyConversion) {
+		return nil, err
+	}
+	for _, tlv := range r.TLVs {
+		hdr.TLVs = setTLV(hdr.TLVs, tlv)
+	}
+	if r.Rewrite != nil {
+		if err := r.Rewrite(hdr); err != nil {
+			return nil, err
+		}
+	}
+	if err := updateCRC32C(hdr); err != nil {
+		return nil, err
+	}
+	return hdr, nil
+}
+
+func (r *Relay) logf(format string, args ...interface{}) {
+	if r.ErrorLog != nil {
+		r.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// headerFromConn derives a header from the addresses of conn.
+func headerFromConn(conn net.Conn) (*Header, error) {
+	src, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported remote address %v", ErrInvalidAddress, conn.RemoteAddr())
+	}
+	dst, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported local address %v", ErrInvalidAddress, conn.LocalAddr())
+	}
+
+	hdr := &Header{
+		Version:           2,
+		Command:           PROXY,
+		TransportProtocol: TCPv6,
+		SrcAddr:           src.IP,
+		DstAddr:           dst.IP,
+		SrcPort:           uint16(src.Port),
+		DstPort:           uint16(dst.Port),
+	}
+	if src.IP.To4() != nil && dst.IP.To4() != nil {
+		hdr.TransportProtocol = TCPv4
+		hdr.SrcAddr = src.IP.To4()
+		hdr.DstAddr = dst.IP.To4()
+	}
+	return hdr, nil
+}
+
+// copyAndCloseWrite copies from src to dst and then half-closes dst
+// so that the peer sees EOF while the other direction keeps flowing.
+func copyAndCloseWrite(dst, src net.Conn) {
+	io.Copy(dst, src)
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
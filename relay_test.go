@@ -0,0 +1,230 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+)
+
+func mustListen(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+func TestRelay_Chain(t *testing.T) {
+	backendLn := &Listener{Listener: mustListen(t)}
+
+	// the second hop forwards the header received from the first one
+	hop2Ln := &Listener{Listener: mustListen(t)}
+	hop2 := &Relay{
+		Backend: backendLn.Addr().String(),
+		TLVs:    []TLV{{Type: PP2_TYPE_AUTHORITY, Value: []byte("hop2.example.com")}},
+		Rewrite: func(hdr *Header) error {
+			hdr.TLVs = append(hdr.TLVs, TLV{Type: 0xE0, Value: []byte{0x02}})
+			return nil
+		},
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	go hop2.Serve(hop2Ln)
+
+	// the first hop derives the header from the client connection
+	hop1Ln := mustListen(t)
+	hop1 := &Relay{
+		Backend: hop2Ln.Addr().String(),
+		Version: 2,
+		TLVs: []TLV{
+			{Type: PP2_TYPE_ALPN, Value: []byte("h2")},
+			{Type: PP2_TYPE_AUTHORITY, Value: []byte("hop1.example.com")},
+		},
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	go hop1.Serve(hop1Ln)
+
+	client, err := net.Dial("tcp", hop1Ln.Addr().String())
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer client.Close()
+	client.Write([]byte("ping"))
+	client.(*net.TCPConn).CloseWrite()
+
+	conn, err := backendLn.Accept()
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	defer conn.Close()
+
+	recv, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if string(recv) != "ping" {
+		t.Errorf("expected 'ping', got %q", recv)
+	}
+
+	if conn.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Errorf("expected '%s', got '%s'", client.LocalAddr(), conn.RemoteAddr())
+	}
+	if conn.LocalAddr().String() != client.RemoteAddr().String() {
+		t.Errorf("expected '%s', got '%s'", client.RemoteAddr(), conn.LocalAddr())
+	}
+	hdr, err := conn.(*Conn).ProxyHeader()
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expectedTLVs := []TLV{
+		{Type: PP2_TYPE_ALPN, Value: []byte("h2")},
+		{Type: PP2_TYPE_AUTHORITY, Value: []byte("hop2.example.com")},
+		{Type: 0xE0, Value: []byte{0x02}},
+	}
+	if hdr.Version != 2 || !assertTLVs(hdr.TLVs, expectedTLVs) {
+		t.Errorf("expected v2 with %v, actual %v", expectedTLVs, hdr)
+	}
+
+	// the client must still be able to read after the half-close
+	conn.Write([]byte("pong"))
+	conn.Close()
+	recv, err = io.ReadAll(client)
+	if err != nil && err != io.EOF {
+		t.Fatal("unexpected error:", err)
+	}
+	if !bytes.Equal(recv, []byte("pong")) {
+		t.Errorf("expected 'pong', got %q", recv)
+	}
+}
+
+func TestRelay_Header(t *testing.T) {
+	client := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}
+	lb := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 443}
+	received := &Header{
+		Version:           2,
+		Command:           PROXY,
+		TransportProtocol: TCPv4,
+		SrcAddr:           client.IP.To4(),
+		DstAddr:           lb.IP.To4(),
+		SrcPort:           uint16(client.Port),
+		DstPort:           uint16(lb.Port),
+		TLVs:              fixtureTLVs,
+	}
+
+	for _, tt := range []struct {
+		name          string
+		relay         *Relay
+		expected      *Header
+		expectedError string
+	}{
+		{
+			name:     "keep version",
+			relay:    &Relay{},
+			expected: received,
+		},
+		{
+			name:  "downgrade to v1",
+			relay: &Relay{Version: 1},
+			expected: &Header{
+				Version:           1,
+				Command:           PROXY,
+				TransportProtocol: TCPv4,
+				SrcAddr:           client.IP,
+				DstAddr:           lb.IP,
+				SrcPort:           uint16(client.Port),
+				DstPort:           uint16(lb.Port),
+			},
+		},
+		{
+			name:          "TLVs in v1",
+			relay:         &Relay{Version: 1, TLVs: fixtureTLVs},
+			expectedError: "TLVs require version 2",
+		},
+		{
+			name: "rewrite error",
+			relay: &Relay{Rewrite: func(*Header) error {
+				return errors.New("rejected")
+			}},
+			expectedError: "rejected",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// a connection whose header has already been read
+			conn := &Conn{
+				conn:   &fakeConn{remote: lb},
+				header: received,
+			}
+			conn.once.Do(func() {})
+
+			actual, err := tt.relay.header(conn)
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Fatalf("expected %q, actual '%v'", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if actual.Version != tt.expected.Version || !assertHeader(actual, tt.expected) {
+				t.Errorf("expected %v, actual %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRelay_CRC32C(t *testing.T) {
+	received := &Header{
+		Version:           2,
+		Command:           PROXY,
+		TransportProtocol: TCPv4,
+		SrcAddr:           v4addr,
+		DstAddr:           v4addr,
+		SrcPort:           PORT,
+		DstPort:           PORT,
+		TLVs: []TLV{
+			{Type: PP2_TYPE_CRC32C, Value: []byte{0x01, 0x02, 0x03, 0x04}},
+			{Type: PP2_TYPE_ALPN, Value: []byte("h2")},
+		},
+	}
+	r := &Relay{TLVs: []TLV{{Type: PP2_TYPE_AUTHORITY, Value: []byte("example.com")}}}
+
+	conn := &Conn{conn: &fakeConn{}, header: received}
+	conn.once.Do(func() {})
+	hdr, err := r.header(conn)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := hdr.WriteTo(buf); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	// the checksum covers the header with the checksum zeroed
+	b := buf.Bytes()
+	i := bytes.Index(b, []byte{byte(PP2_TYPE_CRC32C), 0, 4}) + tlvHeaderLen
+	sum := binary.BigEndian.Uint32(b[i:])
+	copy(b[i:], make([]byte, 4))
+	if expected := crc32.Checksum(b, crc32.MakeTable(crc32.Castagnoli)); sum != expected {
+		t.Errorf("expected %#x, actual %#x", expected, sum)
+	}
+	if !bytes.Equal(received.TLVs[0].Value, []byte{0x01, 0x02, 0x03, 0x04}) {
+		t.Errorf("received header modified: %v", received)
+	}
+}
+
+// fakeConn is a net.Conn with fixed addresses which can't be read from or written to.
+type fakeConn struct {
+	net.Conn
+	remote, local net.Addr
+}
+
+func (c *fakeConn) RemoteAddr() net.Addr { return c.remote }
+func (c *fakeConn) LocalAddr() net.Addr  { return c.local }
@@ -0,0 +1,212 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+var (
+	ErrInvalidTLV = errors.New("proxyproto: invalid TLV")
+)
+
+// PP2Type is the type of a TLV (Type-Length-Value) vector carried by version 2 headers.
+type PP2Type byte
+
+const (
+	PP2_TYPE_ALPN      PP2Type = '\x01'
+	PP2_TYPE_AUTHORITY PP2Type = '\x02'
+	PP2_TYPE_CRC32C    PP2Type = '\x03'
+	PP2_TYPE_NOOP      PP2Type = '\x04'
+	PP2_TYPE_UNIQUE_ID PP2Type = '\x05'
+	PP2_TYPE_SSL       PP2Type = '\x20'
+
+	// TLV header consists of 1 byte of type and 2 bytes of length
+	tlvHeaderLen = 3
+)
+
+// String returns the name of the type as in the specification without the
+// PP2_TYPE_ prefix, e.g. "ALPN", or the byte in hex if the type is not known.
+// Custom types, including those used by cloud providers such as PP2_TYPE_AWS,
+// are shown in hex as applications may use them for their own TLVs.
+func (t PP2Type) String() string {
+	switch t {
+	case PP2_TYPE_ALPN:
+		return "ALPN"
+	case PP2_TYPE_AUTHORITY:
+		return "AUTHORITY"
+	case PP2_TYPE_CRC32C:
+		return "CRC32C"
+	case PP2_TYPE_NOOP:
+		return "NOOP"
+	case PP2_TYPE_UNIQUE_ID:
+		return "UNIQUE_ID"
+	case PP2_TYPE_SSL:
+		return "SSL"
+	case PP2_TYPE_NETNS:
+		return "NETNS"
+	}
+	return fmt.Sprintf("0x%02x", byte(t))
+}
+
+// TLV is a Type-Length-Value vector following the addresses in version 2 headers.
+type TLV struct {
+	Type  PP2Type
+	Value []byte
+
+	// Decoded holds the value decoded by the TLVDecoder registered for Type
+	// with RegisterTLVType, if any. When set, it is encoded in place of
+	// Value on write.
+	Decoded interface{}
+}
+
+// maxTLVStringLen is the longest printable value String shows as is.
+const maxTLVStringLen = 64
+
+// String summarizes the TLV for logging. Short printable values such as ALPN
+// or AUTHORITY are shown quoted, others only by their length.
+func (t TLV) String() string {
+	if len(t.Value) <= maxTLVStringLen && isPrintable(t.Value) {
+		return fmt.Sprintf("%s=%q", t.Type, t.Value)
+	}
+	return fmt.Sprintf("%s(%d bytes)", t.Type, len(t.Value))
+}
+
+func isPrintable(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTLVs parses TLVs in b. offset is the byte offset of b from the start
+// of the header and is only used for error reporting.
+func parseTLVs(b []byte, offset int) ([]TLV, error) {
+	var tlvs []TLV
+	for i := 0; i < len(b); {
+		// Some senders pad the header with zero bytes rather than NOOP TLVs.
+		if isZeroPadding(b[i:]) {
+			break
+		}
+		if len(b)-i < tlvHeaderLen {
+			return nil, newV2ParseError(offset+i, "tlv", b[i:], ErrInvalidTLV)
+		}
+		n := int(binary.BigEndian.Uint16(b[i+1 : i+tlvHeaderLen]))
+		if n > len(b)-i-tlvHeaderLen {
+			return nil, newV2ParseError(offset+i, "tlv", b[i:i+tlvHeaderLen], ErrInvalidTLV)
+		}
+		// NOOP TLVs only pad the header. Type 0 is unassigned and only
+		// found in zero padding, which it can't be told apart from.
+		if typ := PP2Type(b[i]); typ == PP2_TYPE_NOOP || typ == 0 {
+			i += tlvHeaderLen + n
+			continue
+		}
+		tlv := TLV{
+			Type:  PP2Type(b[i]),
+			Value: append([]byte(nil), b[i+tlvHeaderLen:i+tlvHeaderLen+n]...),
+		}
+		decodeTLV(&tlv)
+		tlvs = append(tlvs, tlv)
+		i += tlvHeaderLen + n
+	}
+	return tlvs, nil
+}
+
+func isZeroPadding(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// setTLV returns tlvs with tlv replacing the TLVs of the same type, or appended if there are none.
+func setTLV(tlvs []TLV, tlv TLV) []TLV {
+	out := tlvs[:0:0]
+	for _, t := range tlvs {
+		if t.Type != tlv.Type {
+			out = append(out, t)
+		}
+	}
+	return append(out, tlv)
+}
+
+// updateCRC32C recomputes the CRC32C TLV of hdr, if any, as the checksum of
+// the whole header, so that it stays valid once hdr has been modified.
+func updateCRC32C(hdr *Header) error {
+	if hdr.Version != 2 || !hasTLV(hdr.TLVs, PP2_TYPE_CRC32C) {
+		return nil
+	}
+	// The checksum is computed with the value of the TLV set to zero.
+	sum := make([]byte, 4)
+	hdr.TLVs = setTLV(hdr.TLVs, TLV{Type: PP2_TYPE_CRC32C, Value: sum})
+	buf := &bytes.Buffer{}
+	if _, err := hdr.WriteTo(buf); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(sum, crc32.Checksum(buf.Bytes(), crc32.MakeTable(crc32.Castagnoli)))
+	return nil
+}
+
+func hasTLV(tlvs []TLV, typ PP2Type) bool {
+	for _, tlv := range tlvs {
+		if tlv.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// findSubtypeTLV returns the value following the subtype byte of the first
+// TLV of the given type and subtype in tlvs, as used by vendor specific TLVs.
+func findSubtypeTLV(tlvs []TLV, typ PP2Type, subtype byte) ([]byte, bool) {
+	for _, tlv := range tlvs {
+		if tlv.Type == typ && len(tlv.Value) > 0 && tlv.Value[0] == subtype {
+			return tlv.Value[1:], true
+		}
+	}
+	return nil, false
+}
+
+// setSubtypeTLV returns tlvs with a TLV of the given type and subtype holding
+// value, replacing the TLVs of the same type and subtype if any.
+func setSubtypeTLV(tlvs []TLV, typ PP2Type, subtype byte, value []byte) []TLV {
+	out := tlvs[:0:0]
+	for _, tlv := range tlvs {
+		if tlv.Type != typ || len(tlv.Value) == 0 || tlv.Value[0] != subtype {
+			out = append(out, tlv)
+		}
+	}
+	return append(out, TLV{
+		Type:  typ,
+		Value: append([]byte{subtype}, value...),
+	})
+}
+
+// writeTLVs renders tlvs in a format to write over the wire.
+func writeTLVs(tlvs []TLV) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	for _, tlv := range tlvs {
+		if tlv.Type == 0 {
+			// It would be read back as zero padding.
+			return nil, fmt.Errorf("%w: type 0", ErrInvalidTLV)
+		}
+		value, err := encodeTLV(tlv)
+		if err != nil {
+			return nil, err
+		}
+		if len(value) > maxV2Len {
+			return nil, ErrInvalidTLV
+		}
+		buf.WriteByte(byte(tlv.Type))
+		n := writeUint16ByBE(uint16(len(value)))
+		buf.Write(n[:])
+		buf.Write(value)
+	}
+	return buf.Bytes(), nil
+}
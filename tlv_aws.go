@@ -0,0 +1,25 @@
+package proxyproto
+
+const (
+	// PP2_TYPE_AWS is the custom TLV type used by AWS Network Load Balancers.
+	// Its value starts with one of the PP2_SUBTYPE_AWS_ subtypes.
+	PP2_TYPE_AWS PP2Type = '\xEA'
+
+	// PP2_SUBTYPE_AWS_VPCE_ID is the subtype carrying the ID of the VPC
+	// endpoint the connection came through, for PrivateLink traffic.
+	PP2_SUBTYPE_AWS_VPCE_ID = '\x01'
+)
+
+// AWSVPCEndpointID returns the VPC endpoint ID, e.g. "vpce-08d2bf15fac5001c9",
+// sent by AWS Network Load Balancers for PrivateLink traffic. It returns
+// false if the header carries no such TLV.
+func (h *Header) AWSVPCEndpointID() (string, bool) {
+	v, ok := findSubtypeTLV(h.TLVs, PP2_TYPE_AWS, PP2_SUBTYPE_AWS_VPCE_ID)
+	return string(v), ok
+}
+
+// SetAWSVPCEndpointID sets the VPC endpoint ID TLV as AWS Network Load
+// Balancers do, replacing the existing one if any.
+func (h *Header) SetAWSVPCEndpointID(id string) {
+	h.TLVs = setSubtypeTLV(h.TLVs, PP2_TYPE_AWS, PP2_SUBTYPE_AWS_VPCE_ID, []byte(id))
+}
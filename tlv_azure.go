@@ -0,0 +1,31 @@
+package proxyproto
+
+import "encoding/binary"
+
+const (
+	// PP2_TYPE_AZURE is the custom TLV type used by Azure Private Link.
+	// Its value starts with one of the PP2_SUBTYPE_AZURE_ subtypes.
+	PP2_TYPE_AZURE PP2Type = '\xEE'
+
+	// PP2_SUBTYPE_AZURE_PRIVATEENDPOINT_LINKID is the subtype carrying the
+	// LINKID of the private endpoint the connection came through.
+	PP2_SUBTYPE_AZURE_PRIVATEENDPOINT_LINKID = '\x01'
+)
+
+// AzureLinkID returns the LINKID of the private endpoint sent by Azure
+// Private Link services. It returns false if the header carries no such TLV
+// or if its value is not 4 bytes long.
+func (h *Header) AzureLinkID() (uint32, bool) {
+	v, ok := findSubtypeTLV(h.TLVs, PP2_TYPE_AZURE, PP2_SUBTYPE_AZURE_PRIVATEENDPOINT_LINKID)
+	if !ok || len(v) != 4 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(v), true
+}
+
+// SetAzureLinkID sets the LINKID TLV as Azure Private Link does, replacing
+// the existing one if any.
+func (h *Header) SetAzureLinkID(id uint32) {
+	h.TLVs = setSubtypeTLV(h.TLVs, PP2_TYPE_AZURE, PP2_SUBTYPE_AZURE_PRIVATEENDPOINT_LINKID,
+		binary.LittleEndian.AppendUint32(nil, id))
+}
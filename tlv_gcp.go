@@ -0,0 +1,28 @@
+package proxyproto
+
+import "encoding/binary"
+
+// PP2_TYPE_GCP is the custom TLV type used by Google Cloud Private Service
+// Connect. Its value is the PSC connection ID.
+const PP2_TYPE_GCP PP2Type = '\xE0'
+
+// GCPPSCConnectionID returns the Private Service Connect connection ID sent
+// by Google Cloud load balancers. It returns false if the header carries no
+// such TLV or if its value is not 8 bytes long.
+func (h *Header) GCPPSCConnectionID() (uint64, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == PP2_TYPE_GCP {
+			if len(tlv.Value) != 8 {
+				return 0, false
+			}
+			return binary.BigEndian.Uint64(tlv.Value), true
+		}
+	}
+	return 0, false
+}
+
+// SetGCPPSCConnectionID sets the Private Service Connect connection ID TLV
+// as Google Cloud does, replacing the existing one if any.
+func (h *Header) SetGCPPSCConnectionID(id uint64) {
+	h.TLVs = setTLV(h.TLVs, TLV{Type: PP2_TYPE_GCP, Value: binary.BigEndian.AppendUint64(nil, id)})
+}
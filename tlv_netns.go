@@ -0,0 +1,21 @@
+package proxyproto
+
+// PP2_TYPE_NETNS carries the name of the network namespace the connection
+// was received in, as US-ASCII.
+const PP2_TYPE_NETNS PP2Type = '\x30'
+
+// NetNS returns the network namespace name of the NETNS TLV. It returns
+// false if the header carries no such TLV.
+func (h *Header) NetNS() (string, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == PP2_TYPE_NETNS {
+			return string(tlv.Value), true
+		}
+	}
+	return "", false
+}
+
+// SetNetNS sets the NETNS TLV, replacing the existing one if any.
+func (h *Header) SetNetNS(name string) {
+	h.TLVs = setTLV(h.TLVs, TLV{Type: PP2_TYPE_NETNS, Value: []byte(name)})
+}
@@ -0,0 +1,93 @@
+package proxyproto
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	// PP2_TYPE_MIN_CUSTOM and PP2_TYPE_MAX_CUSTOM bound the range of TLV
+	// types the specification reserves for application specific data.
+	PP2_TYPE_MIN_CUSTOM PP2Type = '\xE0'
+	PP2_TYPE_MAX_CUSTOM PP2Type = '\xEF'
+)
+
+// TLVDecoder decodes the value of a custom TLV into the value stored in
+// TLV.Decoded.
+type TLVDecoder func(value []byte) (interface{}, error)
+
+// TLVEncoder encodes a value stored in TLV.Decoded back into the value of a
+// custom TLV.
+type TLVEncoder func(decoded interface{}) ([]byte, error)
+
+type tlvCodec struct {
+	decode TLVDecoder
+	encode TLVEncoder
+}
+
+var (
+	tlvCodecsMu sync.RWMutex
+	tlvCodecs   = map[PP2Type]tlvCodec{}
+)
+
+// RegisterTLVType registers how to decode and encode the values of the
+// custom TLV type typ. Once registered, Read and the Unmarshal methods set
+// TLV.Decoded for TLVs of that type, and WriteTo and the Marshal methods
+// encode TLV.Decoded, when set, in place of TLV.Value. TLVs of unregistered
+// types, or whose value fails to decode, are only available as raw bytes in
+// TLV.Value.
+//
+// It is meant to be called from init functions and, like http.Handle, it
+// panics if typ is outside of PP2_TYPE_MIN_CUSTOM-PP2_TYPE_MAX_CUSTOM, if
+// decode or encode is nil or if typ is already registered.
+func RegisterTLVType(typ PP2Type, decode TLVDecoder, encode TLVEncoder) {
+	if typ < PP2_TYPE_MIN_CUSTOM || typ > PP2_TYPE_MAX_CUSTOM {
+		panic(fmt.Sprintf("proxyproto: TLV type %s is not a custom type", typ))
+	}
+	if decode == nil || encode == nil {
+		panic("proxyproto: nil TLV decoder or encoder")
+	}
+
+	tlvCodecsMu.Lock()
+	defer tlvCodecsMu.Unlock()
+	if _, ok := tlvCodecs[typ]; ok {
+		panic(fmt.Sprintf("proxyproto: TLV type %s registered twice", typ))
+	}
+	tlvCodecs[typ] = tlvCodec{decode: decode, encode: encode}
+}
+
+func lookupTLVCodec(typ PP2Type) (tlvCodec, bool) {
+	tlvCodecsMu.RLock()
+	defer tlvCodecsMu.RUnlock()
+	c, ok := tlvCodecs[typ]
+	return c, ok
+}
+
+// decodeTLV sets tlv.Decoded if its type is registered. A value failing to
+// decode is left as raw bytes, so that a malformed application TLV doesn't
+// fail the whole header.
+func decodeTLV(tlv *TLV) {
+	c, ok := lookupTLVCodec(tlv.Type)
+	if !ok {
+		return
+	}
+	if decoded, err := c.decode(tlv.Value); err == nil {
+		tlv.Decoded = decoded
+	}
+}
+
+// encodeTLV returns the value of tlv to write over the wire.
+func encodeTLV(tlv TLV) ([]byte, error) {
+	if tlv.Decoded == nil {
+		return tlv.Value, nil
+	}
+	c, ok := lookupTLVCodec(tlv.Type)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s: decoded value of an unregistered type", ErrInvalidTLV, tlv.Type)
+	}
+	value, err := c.encode(tlv.Decoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidTLV, tlv.Type, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,164 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type tenantID uint16
+
+// registerTestTLVType registers tenantID as the custom type 0xE7 for the
+// duration of the test.
+func registerTestTLVType(t *testing.T) PP2Type {
+	const typ PP2Type = 0xE7
+	RegisterTLVType(typ, func(value []byte) (interface{}, error) {
+		if len(value) != 2 {
+			return nil, fmt.Errorf("tenant ID of %d bytes", len(value))
+		}
+		return tenantID(binary.BigEndian.Uint16(value)), nil
+	}, func(decoded interface{}) ([]byte, error) {
+		id, ok := decoded.(tenantID)
+		if !ok {
+			return nil, fmt.Errorf("unexpected %T", decoded)
+		}
+		return binary.BigEndian.AppendUint16(nil, uint16(id)), nil
+	})
+	t.Cleanup(func() {
+		tlvCodecsMu.Lock()
+		delete(tlvCodecs, typ)
+		tlvCodecsMu.Unlock()
+	})
+	return typ
+}
+
+func TestRegisterTLVType(t *testing.T) {
+	typ := registerTestTLVType(t)
+
+	tlvBytes := []byte{byte(typ), 0, 2, 0x01, 0x02, 0xE8, 0, 1, 0xff}
+	length := writeUint16ByBE(uint16(v4AddrLen + len(tlvBytes)))
+	headerBytes := catBytes(SIGV2, proxyBytes, tcpv4Bytes, length[:], fixtureIPv4Address, tlvBytes)
+	hdr, err := Read(newBufioReader(headerBytes))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expectedTLVs := []TLV{{Type: typ, Value: []byte{0x01, 0x02}}, {Type: 0xE8, Value: []byte{0xff}}}
+	if !assertTLVs(hdr.TLVs, expectedTLVs) {
+		t.Errorf("expected %v, actual %v", expectedTLVs, hdr.TLVs)
+	}
+	if hdr.TLVs[0].Decoded != tenantID(0x0102) {
+		t.Errorf("expected %#v, actual %#v", tenantID(0x0102), hdr.TLVs[0].Decoded)
+	}
+	if hdr.TLVs[1].Decoded != nil {
+		t.Errorf("expected unregistered type to stay raw, actual %#v", hdr.TLVs[1].Decoded)
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := hdr.WriteTo(buf); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if !bytes.Equal(buf.Bytes(), headerBytes) {
+		t.Errorf("expected % x, actual % x", headerBytes, buf.Bytes())
+	}
+
+	// Decoded takes precedence over Value
+	hdr.TLVs = []TLV{{Type: typ, Decoded: tenantID(0x0304)}}
+	buf.Reset()
+	if _, err := hdr.WriteTo(buf); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if expected := []byte{byte(typ), 0, 2, 0x03, 0x04}; !bytes.HasSuffix(buf.Bytes(), expected) {
+		t.Errorf("expected % x suffix, actual % x", expected, buf.Bytes())
+	}
+
+	for _, tlvs := range [][]TLV{
+		{{Type: typ, Decoded: "foo"}},
+		{{Type: 0xE8, Decoded: tenantID(1)}},
+	} {
+		hdr.TLVs = tlvs
+		if _, err := hdr.WriteTo(&bytes.Buffer{}); !errors.Is(err, ErrInvalidTLV) {
+			t.Errorf("%v: expected %v, actual %v", tlvs, ErrInvalidTLV, err)
+		}
+	}
+
+	// a malformed value doesn't fail the header
+	tlvBytes = []byte{byte(typ), 0, 1, 0x01}
+	length = writeUint16ByBE(uint16(v4AddrLen + len(tlvBytes)))
+	hdr, err = Read(newBufioReader(catBytes(SIGV2, proxyBytes, tcpv4Bytes, length[:], fixtureIPv4Address, tlvBytes)))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(hdr.TLVs) != 1 || !bytes.Equal(hdr.TLVs[0].Value, []byte{0x01}) || hdr.TLVs[0].Decoded != nil {
+		t.Errorf("expected a raw TLV, actual %#v", hdr.TLVs)
+	}
+}
+
+func TestRegisterTLVType_Marshal(t *testing.T) {
+	typ := registerTestTLVType(t)
+	hdr := &Header{Version: 2, Command: LOCAL, TLVs: []TLV{{Type: typ, Decoded: tenantID(0x0102)}}}
+
+	b, err := json.Marshal(hdr)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if expected := `"tlvs":[{"type":"0xe7","hex":"0102"}]`; !strings.Contains(string(b), expected) {
+		t.Errorf("expected %s in %s", expected, b)
+	}
+	actual := &Header{}
+	if err := json.Unmarshal(b, actual); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if actual.TLVs[0].Decoded != tenantID(0x0102) {
+		t.Errorf("JSON: expected %#v, actual %#v", tenantID(0x0102), actual.TLVs[0].Decoded)
+	}
+
+	b, err = hdr.MarshalText()
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if expected := "tlv=0xe7:0102"; !strings.Contains(string(b), expected) {
+		t.Errorf("expected %s in %s", expected, b)
+	}
+	actual = &Header{}
+	if err := actual.UnmarshalText(b); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if actual.TLVs[0].Decoded != tenantID(0x0102) {
+		t.Errorf("text: expected %#v, actual %#v", tenantID(0x0102), actual.TLVs[0].Decoded)
+	}
+
+	hdr.TLVs[0].Decoded = "foo"
+	if _, err := json.Marshal(hdr); !errors.Is(err, ErrInvalidTLV) {
+		t.Errorf("expected %v, actual %v", ErrInvalidTLV, err)
+	}
+}
+
+func TestRegisterTLVType_Panics(t *testing.T) {
+	typ := registerTestTLVType(t)
+	decode := func([]byte) (interface{}, error) { return nil, nil }
+	encode := func(interface{}) ([]byte, error) { return nil, nil }
+
+	for _, tt := range []struct {
+		name   string
+		typ    PP2Type
+		decode TLVDecoder
+		encode TLVEncoder
+	}{
+		{name: "standard type", typ: PP2_TYPE_ALPN, decode: decode, encode: encode},
+		{name: "nil decoder", typ: 0xE8, encode: encode},
+		{name: "registered twice", typ: typ, decode: decode, encode: encode},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic")
+				}
+			}()
+			RegisterTLVType(tt.typ, tt.decode, tt.encode)
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package proxyproto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var (
+	fixtureTLVs = []TLV{
+		{Type: PP2_TYPE_ALPN, Value: []byte("h2")},
+		{Type: PP2_TYPE_AUTHORITY, Value: []byte("example.com")},
+		{Type: PP2_TYPE_UNIQUE_ID, Value: []byte{}},
+	}
+	fixtureTLVBytes = catBytes(
+		[]byte{byte(PP2_TYPE_ALPN), 0, 2}, []byte("h2"),
+		[]byte{byte(PP2_TYPE_AUTHORITY), 0, 11}, []byte("example.com"),
+		[]byte{byte(PP2_TYPE_UNIQUE_ID), 0, 0},
+	)
+)
+
+func TestReadWriteV2TLV(t *testing.T) {
+	tlvLen := writeUint16ByBE(uint16(v4AddrLen + len(fixtureTLVBytes)))
+	headerBytes := catBytes(SIGV2, proxyBytes, tcpv4Bytes, tlvLen[:], fixtureIPv4Address, fixtureTLVBytes)
+	expected := &Header{
+		Version:           2,
+		Command:           PROXY,
+		TransportProtocol: TCPv4,
+		SrcAddr:           v4addr,
+		DstAddr:           v4addr,
+		SrcPort:           PORT,
+		DstPort:           PORT,
+		TLVs:              fixtureTLVs,
+	}
+
+	t.Run("Read", func(t *testing.T) {
+		actual, err := Read(newBufioReader(headerBytes))
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if !assertHeader(actual, expected) {
+			t.Fatalf("expected %#v, actual %#v", expected, actual)
+		}
+	})
+
+	t.Run("Write", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		if _, err := expected.WriteTo(buf); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if !bytes.Equal(buf.Bytes(), headerBytes) {
+			t.Fatalf("expected %#v, actual %#v", headerBytes, buf.Bytes())
+		}
+	})
+}
+
+func TestParseTLVs(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		bytes         []byte
+		expectedTLVs  []TLV
+		expectedError error
+	}{
+		{
+			name:         "TLVs",
+			bytes:        fixtureTLVBytes,
+			expectedTLVs: fixtureTLVs,
+		},
+		{
+			name:         "zero padding",
+			bytes:        catBytes(fixtureTLVBytes, make([]byte, 10)),
+			expectedTLVs: fixtureTLVs,
+		},
+		{
+			name:         "NOOP",
+			bytes:        catBytes([]byte{byte(PP2_TYPE_NOOP), 0, 2, 0, 0}, fixtureTLVBytes, []byte{byte(PP2_TYPE_NOOP), 0, 0}),
+			expectedTLVs: fixtureTLVs,
+		},
+		{
+			name:         "type 0",
+			bytes:        catBytes([]byte{0, 0, 1, 0xff}, fixtureTLVBytes, []byte{0, 0, 0}),
+			expectedTLVs: fixtureTLVs,
+		},
+		{
+			name:          "truncated type and length",
+			bytes:         catBytes(fixtureTLVBytes, []byte{byte(PP2_TYPE_ALPN), 0}),
+			expectedError: ErrInvalidTLV,
+		},
+		{
+			name:          "truncated value",
+			bytes:         catBytes([]byte{byte(PP2_TYPE_ALPN), 0, 3}, []byte("h2")),
+			expectedError: ErrInvalidTLV,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := parseTLVs(tt.bytes, 0)
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("expected '%v', actual '%v'", tt.expectedError, err)
+			}
+			if !assertTLVs(actual, tt.expectedTLVs) {
+				t.Fatalf("expected %#v, actual %#v", tt.expectedTLVs, actual)
+			}
+		})
+	}
+}
+
+func TestWriteV2TLVType0(t *testing.T) {
+	hdr := &Header{
+		Version:           2,
+		Command:           PROXY,
+		TransportProtocol: TCPv4,
+		SrcAddr:           v4addr,
+		DstAddr:           v4addr,
+		TLVs:              []TLV{{Type: PP2_TYPE_ALPN, Value: []byte("h2")}, {Type: 0}},
+	}
+	if _, err := hdr.WriteTo(&bytes.Buffer{}); !errors.Is(err, ErrInvalidTLV) {
+		t.Errorf("expected '%v', actual '%v'", ErrInvalidTLV, err)
+	}
+}
+
+func TestWriteV2Padding(t *testing.T) {
+	// 16 bytes of fixed header, 12 of addresses and 5 of the ALPN TLV
+	const unpaddedLen = 33
+
+	for _, tt := range []struct {
+		name          string
+		padSize       int
+		padAlign      int
+		expectedLen   int
+		expectedError error
+	}{
+		{name: "none", expectedLen: unpaddedLen},
+		{name: "size", padSize: 64, expectedLen: 64},
+		{name: "exact size", padSize: unpaddedLen, expectedLen: unpaddedLen},
+		{name: "minimum size", padSize: unpaddedLen + tlvHeaderLen, expectedLen: unpaddedLen + tlvHeaderLen},
+		{name: "size too short for a NOOP TLV", padSize: unpaddedLen + 2, expectedError: ErrInvalidLength},
+		{name: "size too small", padSize: 32, expectedError: ErrHeaderTooLarge},
+		{name: "align", padAlign: 16, expectedLen: 48},
+		{name: "align to the next multiple", padAlign: 5, expectedLen: 40},
+		{name: "aligned", padAlign: 11, expectedLen: unpaddedLen},
+		{name: "size over align", padSize: 100, padAlign: 16, expectedLen: 100},
+		{name: "maximum size", padSize: v2AddressOffset + maxV2Len, expectedLen: v2AddressOffset + maxV2Len},
+		{name: "size too large", padSize: 1 << 30, expectedError: ErrInvalidLength},
+		{name: "align too large", padAlign: 1 << 30, expectedError: ErrInvalidLength},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			hdr := &Header{
+				Version:           2,
+				Command:           PROXY,
+				TransportProtocol: TCPv4,
+				SrcAddr:           v4addr,
+				DstAddr:           v4addr,
+				SrcPort:           PORT,
+				DstPort:           PORT,
+				TLVs:              []TLV{{Type: PP2_TYPE_ALPN, Value: []byte("h2")}},
+				PadSize:           tt.padSize,
+				PadAlign:          tt.padAlign,
+			}
+			buf := &bytes.Buffer{}
+			_, err := hdr.WriteTo(buf)
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("expected '%v', actual '%v'", tt.expectedError, err)
+			}
+			if err != nil {
+				return
+			}
+			if buf.Len() != tt.expectedLen {
+				t.Fatalf("expected %d bytes, actual %d: % x", tt.expectedLen, buf.Len(), buf.Bytes())
+			}
+			if tt.expectedLen > unpaddedLen {
+				noop := buf.Bytes()[unpaddedLen:]
+				if noop[0] != byte(PP2_TYPE_NOOP) || int(noop[1])<<8|int(noop[2]) != len(noop)-tlvHeaderLen {
+					t.Fatalf("expected a NOOP TLV, actual % x", noop)
+				}
+			}
+
+			actual, err := Read(newBufioReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if !assertHeader(actual, hdr) {
+				t.Errorf("expected %#v, actual %#v", hdr, actual)
+			}
+		})
+	}
+}
+
+func TestReadV2LargerThanBuffer(t *testing.T) {
+	// e.g. an SSL TLV carrying certificate details
+	hdr := &Header{
+		Version:           2,
+		Command:           PROXY,
+		TransportProtocol: TCPv4,
+		SrcAddr:           v4addr,
+		DstAddr:           v4addr,
+		SrcPort:           PORT,
+		DstPort:           PORT,
+		TLVs:              []TLV{{Type: PP2_TYPE_SSL, Value: bytes.Repeat([]byte{0x01}, maxV2Len-v4AddrLen-tlvHeaderLen)}},
+	}
+	buf := &bytes.Buffer{}
+	if _, err := hdr.WriteTo(buf); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	buf.WriteString("ping")
+
+	br := newBufioReader(buf.Bytes())
+	actual, err := Read(br)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if !assertHeader(actual, hdr) {
+		t.Fatalf("expected %v, actual %v", hdr, actual)
+	}
+	if rest, _ := br.Peek(4); string(rest) != "ping" {
+		t.Errorf("expected 'ping' after the header, actual %q", rest)
+	}
+
+	_, err = read(newBufioReader(buf.Bytes()), 4096)
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Err != ErrHeaderTooLarge || perr.Field != "length" {
+		t.Errorf("expected '%v', actual '%v'", ErrHeaderTooLarge, err)
+	}
+}
+
+func TestWriteV2TooLong(t *testing.T) {
+	hdr := &Header{
+		Version:           2,
+		Command:           PROXY,
+		TransportProtocol: TCPv4,
+		SrcAddr:           v4addr,
+		DstAddr:           v4addr,
+		TLVs:              []TLV{{Type: PP2_TYPE_UNIQUE_ID, Value: make([]byte, maxV2Len-tlvHeaderLen)}},
+	}
+	if _, err := hdr.WriteTo(&bytes.Buffer{}); err != ErrInvalidLength {
+		t.Fatalf("expected '%v', actual '%v'", ErrInvalidLength, err)
+	}
+}
+
+func TestTLVAccessors(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		get      func(*Header) (interface{}, bool)
+		set      func(*Header)
+		expected interface{}
+		tlv      TLV   // as set and sent over the wire
+		other    TLV   // an unrelated TLV kept by set
+		invalid  []TLV // TLVs get ignores
+	}{
+		{
+			name:     "AWS VPC endpoint ID",
+			get:      func(h *Header) (interface{}, bool) { return h.AWSVPCEndpointID() },
+			set:      func(h *Header) { h.SetAWSVPCEndpointID("vpce-08d2bf15fac5001c9") },
+			expected: "vpce-08d2bf15fac5001c9",
+			tlv:      TLV{Type: PP2_TYPE_AWS, Value: append([]byte{PP2_SUBTYPE_AWS_VPCE_ID}, "vpce-08d2bf15fac5001c9"...)},
+			other:    TLV{Type: PP2_TYPE_AWS, Value: []byte{0x02, 0xff}},
+			invalid:  []TLV{{Type: PP2_TYPE_AWS}},
+		},
+		{
+			name:     "Azure LINKID",
+			get:      func(h *Header) (interface{}, bool) { return h.AzureLinkID() },
+			set:      func(h *Header) { h.SetAzureLinkID(0x12345678) },
+			expected: uint32(0x12345678),
+			tlv:      TLV{Type: PP2_TYPE_AZURE, Value: []byte{PP2_SUBTYPE_AZURE_PRIVATEENDPOINT_LINKID, 0x78, 0x56, 0x34, 0x12}},
+			other:    TLV{Type: PP2_TYPE_AZURE, Value: []byte{0x02, 0xff}},
+			invalid:  []TLV{{Type: PP2_TYPE_AZURE, Value: []byte{PP2_SUBTYPE_AZURE_PRIVATEENDPOINT_LINKID, 0x01}}},
+		},
+		{
+			name:     "GCP PSC connection ID",
+			get:      func(h *Header) (interface{}, bool) { return h.GCPPSCConnectionID() },
+			set:      func(h *Header) { h.SetGCPPSCConnectionID(0x0102030405060708) },
+			expected: uint64(0x0102030405060708),
+			tlv:      TLV{Type: PP2_TYPE_GCP, Value: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}},
+			other:    TLV{Type: PP2_TYPE_ALPN, Value: []byte("h2")},
+			invalid:  []TLV{{Type: PP2_TYPE_GCP, Value: []byte{0x01}}},
+		},
+		{
+			name:     "NETNS",
+			get:      func(h *Header) (interface{}, bool) { return h.NetNS() },
+			set:      func(h *Header) { h.SetNetNS("tenant-1") },
+			expected: "tenant-1",
+			tlv:      TLV{Type: PP2_TYPE_NETNS, Value: []byte("tenant-1")},
+			other:    TLV{Type: PP2_TYPE_ALPN, Value: []byte("h2")},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tlvLen := writeUint16ByBE(uint16(v4AddrLen + tlvHeaderLen + len(tt.tlv.Value)))
+			headerBytes := catBytes(SIGV2, proxyBytes, tcpv4Bytes, tlvLen[:], fixtureIPv4Address,
+				[]byte{byte(tt.tlv.Type), 0, byte(len(tt.tlv.Value))}, tt.tlv.Value)
+			hdr, err := Read(newBufioReader(headerBytes))
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if actual, ok := tt.get(hdr); !ok || actual != tt.expected {
+				t.Errorf("expected %v, actual %v (%v)", tt.expected, actual, ok)
+			}
+
+			// set replaces the TLV and keeps others
+			hdr.TLVs = []TLV{tt.tlv, tt.other}
+			tt.set(hdr)
+			expectedTLVs := []TLV{tt.other, tt.tlv}
+			if !assertTLVs(hdr.TLVs, expectedTLVs) {
+				t.Errorf("expected %v, actual %v", expectedTLVs, hdr.TLVs)
+			}
+			buf := &bytes.Buffer{}
+			hdr.TLVs = nil
+			tt.set(hdr)
+			if _, err := hdr.WriteTo(buf); err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if !bytes.Equal(buf.Bytes(), headerBytes) {
+				t.Errorf("expected % x, actual % x", headerBytes, buf.Bytes())
+			}
+
+			for _, tlvs := range append([][]TLV{nil, {tt.other}}, tt.invalid) {
+				if actual, ok := tt.get(&Header{TLVs: tlvs}); ok {
+					t.Errorf("%v: expected none, actual %v", tlvs, actual)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoTrustedNetworks is returned by LoadFile for a file listing no network.
+var ErrNoTrustedNetworks = errors.New("proxyproto: no trusted networks")
+
+// TrustedSet is a set of networks allowed to send the PROXY protocol header.
+// It can be updated at any time, e.g. from a watched file, while in use by
+// listeners: updates replace the whole set atomically so that a check never
+// sees a partially updated set.
+//
+// Use its SourceCheck method as Listener.SourceCheck:
+//
+//	trusted, err := proxyproto.NewTrustedSet("10.0.0.0/8")
+//	pl := &proxyproto.Listener{Listener: ln, SourceCheck: trusted.SourceCheck}
+type TrustedSet struct {
+	nets atomic.Pointer[[]*net.IPNet]
+}
+
+// NewTrustedSet returns a set of the given networks in CIDR notation. Single
+// addresses are accepted as well.
+func NewTrustedSet(cidrs ...string) (*TrustedSet, error) {
+	s := &TrustedSet{}
+	if err := s.Set(cidrs...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Set replaces the networks in the set. On error, the set is left unchanged.
+func (s *TrustedSet) Set(cidrs ...string) error {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		n, err := parseTrustedNet(cidr)
+		if err != nil {
+			return err
+		}
+		nets = append(nets, n)
+	}
+	s.nets.Store(&nets)
+	return nil
+}
+
+// Nets returns the networks in the set.
+func (s *TrustedSet) Nets() []*net.IPNet {
+	nets := s.nets.Load()
+	if nets == nil {
+		return nil
+	}
+	return append([]*net.IPNet(nil), *nets...)
+}
+
+// Contains returns true if ip belongs to one of the networks in the set.
+func (s *TrustedSet) Contains(ip net.IP) bool {
+	nets := s.nets.Load()
+	if nets == nil {
+		return false
+	}
+	for _, n := range *nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceCheck is a SourceChecker trusting the PROXY info only from addresses
+// in the set.
+func (s *TrustedSet) SourceCheck(addr net.Addr) (bool, error) {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return s.Contains(addr.IP), nil
+	case *net.UDPAddr:
+		return s.Contains(addr.IP), nil
+	}
+	return false, nil
+}
+
+// LoadFile replaces the networks in the set with the ones listed in the file,
+// one per line. Blank lines and comments starting with # are ignored. On
+// error, the set is left unchanged.
+//
+// A file listing no network fails with ErrNoTrustedNetworks, as it is likely
+// read while being rewritten. Use Set to empty the set on purpose.
+func (s *TrustedSet) LoadFile(filename string) error {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var cidrs []string
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; sc.Scan(); line++ {
+		cidr := sc.Text()
+		if i := strings.IndexByte(cidr, '#'); i >= 0 {
+			cidr = cidr[:i]
+		}
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if _, err := parseTrustedNet(cidr); err != nil {
+			return fmt.Errorf("%s:%d: %w", filename, line, err)
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if len(cidrs) == 0 {
+		return fmt.Errorf("%s: %w", filename, ErrNoTrustedNetworks)
+	}
+	return s.Set(cidrs...)
+}
+
+// WatchFile loads the file with LoadFile and then reloads it whenever its
+// modification time or size changes, checking every interval, until ctx is
+// done. It returns the error of the initial load, if any, and ctx.Err()
+// otherwise. Later errors are logged and leave the set unchanged, so that a
+// bad edit or a file being rewritten doesn't drop all trusted networks, and
+// the file is reloaded again on the next check until it loads. interval must
+// be positive.
+func (s *TrustedSet) WatchFile(ctx context.Context, filename string, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("proxyproto: non-positive interval %v to watch %s", interval, filename)
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if err := s.LoadFile(filename); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		cur, err := os.Stat(filename)
+		if err != nil {
+			log.Printf("[ERR] Failed to reload trusted networks: %v", err)
+			continue
+		}
+		if cur.ModTime().Equal(fi.ModTime()) && cur.Size() == fi.Size() {
+			continue
+		}
+		// A failed load, e.g. of a file being written, is retried on the next tick.
+		if err := s.LoadFile(filename); err != nil {
+			log.Printf("[ERR] Failed to reload trusted networks: %v", err)
+			continue
+		}
+		fi = cur
+	}
+}
+
+// parseTrustedNet parses a network in CIDR notation or a single address.
+func parseTrustedNet(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAddress, s)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAddress, s)
+	}
+	return n, nil
+}
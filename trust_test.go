@@ -0,0 +1,188 @@
+package proxyproto
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTrustedSet(t *testing.T) {
+	s, err := NewTrustedSet("10.0.0.0/8", "192.0.2.1", "2001:db8::/32")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	for _, tt := range []struct {
+		addr     net.Addr
+		expected bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: PORT}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: PORT}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: PORT}, false},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: PORT}, true},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db9::1"), Port: PORT}, false},
+		{&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, false},
+	} {
+		actual, err := s.SourceCheck(tt.addr)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if actual != tt.expected {
+			t.Errorf("%s: expected %v, actual %v", tt.addr, tt.expected, actual)
+		}
+	}
+
+	if err := s.Set("10.0.0.0/8", "invalid"); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("expected '%v', actual '%v'", ErrInvalidAddress, err)
+	}
+	if len(s.Nets()) != 3 {
+		t.Errorf("expected the set to be unchanged, actual %v", s.Nets())
+	}
+
+	var empty TrustedSet
+	if empty.Contains(net.ParseIP("10.1.2.3")) {
+		t.Error("expected the zero value to be empty")
+	}
+}
+
+func TestTrustedSet_LoadFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "trusted")
+	writeFile(t, filename, "# load balancers\n10.0.0.0/8\n\n  192.0.2.1  # health check\n")
+
+	s := &TrustedSet{}
+	if err := s.LoadFile(filename); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if !s.Contains(net.ParseIP("10.1.2.3")) || !s.Contains(net.ParseIP("192.0.2.1")) || len(s.Nets()) != 2 {
+		t.Errorf("unexpected networks %v", s.Nets())
+	}
+
+	writeFile(t, filename, "10.0.0.0/8\n10.0.0.0/33\n")
+	err := s.LoadFile(filename)
+	if !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("expected '%v', actual '%v'", ErrInvalidAddress, err)
+	}
+	if expected := filename + ":2:"; err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Errorf("expected the line number in '%v'", err)
+	}
+	if len(s.Nets()) != 2 {
+		t.Errorf("expected the set to be unchanged, actual %v", s.Nets())
+	}
+
+	for _, content := range []string{"", "# nothing yet\n\n"} {
+		writeFile(t, filename, content)
+		if err := s.LoadFile(filename); !errors.Is(err, ErrNoTrustedNetworks) {
+			t.Errorf("%q: expected '%v', actual '%v'", content, ErrNoTrustedNetworks, err)
+		}
+		if len(s.Nets()) != 2 {
+			t.Errorf("%q: expected the set to be unchanged, actual %v", content, s.Nets())
+		}
+	}
+}
+
+func TestTrustedSet_WatchFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "trusted")
+	writeFile(t, filename, "10.0.0.0/8\n")
+
+	s := &TrustedSet{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.WatchFile(ctx, filename, 10*time.Millisecond)
+	}()
+
+	waitFor(t, func() bool { return s.Contains(net.ParseIP("10.1.2.3")) })
+
+	writeFile(t, filename, "192.0.2.0/24\n")
+	waitFor(t, func() bool { return s.Contains(net.ParseIP("192.0.2.1")) })
+	if s.Contains(net.ParseIP("10.1.2.3")) {
+		t.Error("expected 10.0.0.0/8 to be removed")
+	}
+
+	// a file truncated before being rewritten leaves the set unchanged
+	writeFile(t, filename, "")
+	time.Sleep(50 * time.Millisecond)
+	if !s.Contains(net.ParseIP("192.0.2.1")) {
+		t.Error("expected an empty file to leave the set unchanged")
+	}
+
+	// a failed reload is retried even if the file is fixed with the same
+	// modification time and size
+	writeFile(t, filename, "198.51.100.0/2x\n")
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !s.Contains(net.ParseIP("192.0.2.1")) {
+		t.Error("expected a bad edit to leave the set unchanged")
+	}
+	writeFile(t, filename, "198.51.100.0/24\n")
+	if err := os.Chtimes(filename, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	waitFor(t, func() bool { return s.Contains(net.ParseIP("198.51.100.1")) })
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected '%v', actual '%v'", context.Canceled, err)
+	}
+
+	if err := s.WatchFile(context.Background(), filepath.Join(t.TempDir(), "missing"), time.Second); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, actual '%v'", err)
+	}
+	if err := s.WatchFile(context.Background(), filename, 0); err == nil {
+		t.Error("expected an error with a zero interval")
+	}
+}
+
+func TestTrustedSet_Concurrent(t *testing.T) {
+	s, err := NewTrustedSet("127.0.0.1")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: PORT}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			s.Set("127.0.0.0/8", "10.0.0.0/8")
+			s.Set("127.0.0.1")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			if ok, _ := s.SourceCheck(addr); !ok {
+				t.Error("expected 127.0.0.1 to be always trusted")
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func writeFile(t *testing.T, filename, content string) {
+	t.Helper()
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal("timed out waiting for the condition")
+}
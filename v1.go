@@ -9,21 +9,41 @@ import (
 	"strings"
 )
 
-const v1Sep = " "
+const (
+	v1Sep = " "
+
+	// maxV1Len is the maximum length of a v1 header including the CRLF,
+	// as per specification.
+	maxV1Len = 107
+)
 
 func parseVersion1(br *bufio.Reader) (*Header, error) {
 	// Make sure we have a v1 header
-	line, err := br.ReadString('\n')
+	line, err := readV1Line(br)
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
 
 	if !strings.HasSuffix(line, CRLF) {
-		return nil, ErrCantReadProtocolVersionAndCommand
+		return nil, newV1ParseError(len(line), "line", line, ErrCantReadProtocolVersionAndCommand)
 	}
 	tokens := strings.Split(line[:len(line)-2], v1Sep)
+	if len(tokens) >= 2 && tokens[1] == "UNKNOWN" {
+		// The receiver must ignore anything presented before the CRLF and
+		// use the real connection endpoints.
+		return &Header{
+			Version:           1,
+			TransportProtocol: UNSPEC,
+		}, nil
+	}
 	if len(tokens) < 6 {
-		return nil, ErrCantReadProtocolVersionAndCommand
+		return nil, newV1ParseError(0, "line", line, ErrCantReadProtocolVersionAndCommand)
+	}
+
+	// offsets[i] is the byte offset of tokens[i] from the start of the line
+	offsets := make([]int, len(tokens))
+	for i := 1; i < len(tokens); i++ {
+		offsets[i] = offsets[i-1] + len(tokens[i-1]) + len(v1Sep)
 	}
 
 	hdr := &Header{
@@ -43,23 +63,50 @@ func parseVersion1(br *bufio.Reader) (*Header, error) {
 	// Read addresses and ports
 	hdr.SrcAddr, err = parseV1IPAddress(hdr.TransportProtocol, tokens[2])
 	if err != nil {
-		return nil, err
+		return nil, newV1ParseError(offsets[2], "source address", tokens[2], err)
 	}
 	hdr.DstAddr, err = parseV1IPAddress(hdr.TransportProtocol, tokens[3])
 	if err != nil {
-		return nil, err
+		return nil, newV1ParseError(offsets[3], "destination address", tokens[3], err)
 	}
 	hdr.SrcPort, err = parseV1Port(tokens[4])
 	if err != nil {
-		return nil, err
+		return nil, newV1ParseError(offsets[4], "source port", tokens[4], err)
 	}
 	hdr.DstPort, err = parseV1Port(tokens[5])
 	if err != nil {
-		return nil, err
+		return nil, newV1ParseError(offsets[5], "destination port", tokens[5], err)
 	}
 	return hdr, nil
 }
 
+// readV1Line reads up to and including the first LF, failing with
+// ErrHeaderTooLarge if it isn't found within maxV1Len bytes.
+func readV1Line(br *bufio.Reader) (string, error) {
+	var b strings.Builder
+	for b.Len() < maxV1Len {
+		c, err := br.ReadByte()
+		if err != nil {
+			return b.String(), err
+		}
+		b.WriteByte(c)
+		if c == '\n' {
+			return b.String(), nil
+		}
+	}
+	return "", newV1ParseError(maxV1Len, "line", b.String(), ErrHeaderTooLarge)
+}
+
+func newV1ParseError(offset int, field, token string, err error) *ParseError {
+	return &ParseError{
+		Version: 1,
+		Offset:  offset,
+		Field:   field,
+		Bytes:   []byte(token),
+		Err:     err,
+	}
+}
+
 func (h *Header) writeVersion1(w io.Writer) (int64, error) {
 	// As of version 1, only "TCP4" ( \x54 \x43 \x50 \x34 ) for TCP over IPv4,
 	// and "TCP6" ( \x54 \x43 \x50 \x36 ) for TCP over IPv6 are allowed.
@@ -75,6 +122,11 @@ func (h *Header) writeVersion1(w io.Writer) (int64, error) {
 	buf.Write(SIGV1)
 	buf.WriteString(v1Sep)
 	buf.WriteString(proto)
+	if proto == "UNKNOWN" {
+		// the receiver ignores the remaining of the line
+		buf.WriteString(CRLF)
+		return buf.WriteTo(w)
+	}
 	buf.WriteString(v1Sep)
 	buf.WriteString(h.SrcAddr.String())
 	buf.WriteString(v1Sep)
@@ -91,7 +143,7 @@ func (h *Header) writeVersion1(w io.Writer) (int64, error) {
 func parseV1Port(portStr string) (uint16, error) {
 	port, err := strconv.Atoi(portStr)
 	if err != nil {
-		return 0, err
+		return 0, ErrInvalidPortNumber
 	}
 	if port < 0 || port > 65535 {
 		return 0, ErrInvalidPortNumber
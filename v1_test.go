@@ -3,9 +3,11 @@ package proxyproto
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -45,6 +47,10 @@ func TestReadV1Invalid(t *testing.T) {
 			[]byte("PROXY TCP4 " + tcp4AddrsPorts),
 			ErrCantReadProtocolVersionAndCommand,
 		},
+		{
+			append([]byte("PROXY TCP4 "), bytes.Repeat([]byte{' '}, maxV1Len)...),
+			ErrHeaderTooLarge,
+		},
 		{
 			[]byte("PROXY TCP6 " + tcp4AddrsPorts + CRLF),
 			ErrInvalidAddress,
@@ -54,12 +60,50 @@ func TestReadV1Invalid(t *testing.T) {
 			ErrInvalidAddress,
 		},
 	} {
-		if _, err := Read(newBufioReader(tt.bytes)); err != tt.expectedError {
+		if _, err := Read(newBufioReader(tt.bytes)); !errors.Is(err, tt.expectedError) {
 			t.Fatalf("'%s': expected '%s', actual '%s'", string(tt.bytes), tt.expectedError, err)
 		}
 	}
 }
 
+func TestReadWriteV1Unknown(t *testing.T) {
+	// anything after UNKNOWN is ignored
+	for _, line := range []string{"PROXY UNKNOWN\r\n", "PROXY UNKNOWN " + tcp4AddrsPorts + CRLF, "PROXY UNKNOWN garbage\r\n"} {
+		hdr, err := Read(newBufioReader([]byte(line)))
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", line, err)
+		}
+		if hdr.String() != "PROXY v1 UNSPEC" || hdr.SrcAddr != nil || hdr.DstAddr != nil {
+			t.Errorf("%q: expected UNKNOWN header, actual %#v", line, hdr)
+		}
+	}
+
+	// addresses are not written with UNKNOWN
+	buf := &bytes.Buffer{}
+	hdr := &Header{Version: 1, Command: PROXY, TransportProtocol: UNSPEC, SrcAddr: v4addr, DstAddr: v4addr}
+	if _, err := hdr.WriteTo(buf); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if buf.String() != "PROXY UNKNOWN\r\n" {
+		t.Errorf("expected %q, actual %q", "PROXY UNKNOWN\r\n", buf.String())
+	}
+}
+
+func TestReadV1MaxLength(t *testing.T) {
+	line := "PROXY UNKNOWN "
+	line += strings.Repeat("f", maxV1Len-len(line)-len(CRLF)) + CRLF
+	if hdr, err := Read(newBufioReader([]byte(line))); err != nil || hdr.TransportProtocol != UNSPEC {
+		t.Errorf("expected UNKNOWN header, actual %v (%v)", hdr, err)
+	}
+
+	line = "PROXY UNKNOWN " + strings.Repeat("f", maxV1Len) + CRLF
+	_, err := Read(newBufioReader([]byte(line)))
+	var perr *ParseError
+	if !errors.Is(err, ErrHeaderTooLarge) || !errors.As(err, &perr) || perr.Version != 1 {
+		t.Errorf("expected '%v', actual '%v'", ErrHeaderTooLarge, err)
+	}
+}
+
 func TestReadWriteV1Valid(t *testing.T) {
 	for _, tt := range []struct {
 		str            string
@@ -143,11 +187,15 @@ func TestParseV1Port(t *testing.T) {
 			PortStr: "65536",
 			IsError: true,
 		},
+		{
+			PortStr: "http",
+			IsError: true,
+		},
 	} {
 		port, err := parseV1Port(tt.PortStr)
 		if tt.IsError {
-			if err == nil {
-				t.Error("expected error:", err)
+			if err != ErrInvalidPortNumber {
+				t.Errorf("expected '%s', got '%v'", ErrInvalidPortNumber, err)
 			}
 		} else {
 			if err != nil {
@@ -222,3 +270,32 @@ func TestParseV1IPAddress(t *testing.T) {
 		})
 	}
 }
+
+func FuzzParseVersion1(f *testing.F) {
+	for _, s := range []string{
+		"PROXY \r\n",
+		"PROXY TCP4 " + tcp4AddrsPorts,
+		"PROXY TCP4 " + tcp4AddrsPorts + CRLF + "GET /",
+		"PROXY TCP6 " + tcp6AddrsPorts + CRLF + "GET /",
+		"PROXY TCP6 " + tcp4AddrsPorts + CRLF,
+		"PROXY TCP4 " + tcp6AddrsPorts + CRLF,
+		"PROXY UNKNOWN\r\n",
+	} {
+		f.Add([]byte(s))
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		hdr, err := parseVersion1(newBufioReader(b))
+		if err != nil {
+			var perr *ParseError
+			if !errors.As(err, &perr) || perr.Version != 1 {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+			return
+		}
+		if hdr.Version != 1 {
+			t.Fatalf("unexpected version: %d", hdr.Version)
+		}
+		assertRoundTrip(t, hdr)
+	})
+}
@@ -4,13 +4,22 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
-	"io/ioutil"
 )
 
 const (
 	v4AddrLen = 12
 	v6AddrLen = 36
+
+	// Byte offsets of the fields following the signature
+	v2CommandOffset = 12
+	v2FamilyOffset  = 13
+	v2LengthOffset  = 14
+	v2AddressOffset = 16
+
+	// maxV2Len is the largest length of the payload section following the length field
+	maxV2Len = 1<<16 - 1
 )
 
 var (
@@ -36,11 +45,13 @@ type _addr6 struct {
 	_ports
 }
 
-func parseVersion2(br *bufio.Reader) (*Header, error) {
+// parseVersion2 parses a version 2 header. If maxHeaderSize is positive,
+// headers larger than maxHeaderSize bytes are rejected with ErrHeaderTooLarge.
+func parseVersion2(br *bufio.Reader, maxHeaderSize int) (*Header, error) {
 	// Skip first 12 bytes (signature)
 	n, err := br.Discard(len(SIGV2))
 	if err != nil || n != len(SIGV2) {
-		return nil, ErrCantReadProtocolVersionAndCommand
+		return nil, newV2ParseError(n, "signature", nil, ErrCantReadProtocolVersionAndCommand)
 	}
 
 	hdr := &Header{
@@ -50,44 +61,48 @@ func parseVersion2(br *bufio.Reader) (*Header, error) {
 	// Read the 13th byte, protocol version and command
 	b13, err := br.ReadByte()
 	if err != nil {
-		return nil, ErrCantReadProtocolVersionAndCommand
+		return nil, newV2ParseError(v2CommandOffset, "command", nil, ErrCantReadProtocolVersionAndCommand)
 	}
 
 	hdr.Command = ProtocolVersionAndCommand(b13)
 	if !isSupportedCommand(hdr.Command) {
-		return nil, ErrUnsupportedProtocolVersionAndCommand
+		return nil, newV2ParseError(v2CommandOffset, "command", []byte{b13}, ErrUnsupportedProtocolVersionAndCommand)
 	}
 
 	// Read the 14th byte, address family and protocol
 	b14, err := br.ReadByte()
 	if err != nil {
-		return nil, ErrCantReadAddressFamilyAndProtocol
+		return nil, newV2ParseError(v2FamilyOffset, "address family and protocol", nil, ErrCantReadAddressFamilyAndProtocol)
 	}
 	hdr.TransportProtocol = AddressFamilyAndProtocol(b14)
 	if !isSupportedTransportProtocol(hdr.TransportProtocol) {
-		return nil, ErrUnsupportedAddressFamilyAndProtocol
+		return nil, newV2ParseError(v2FamilyOffset, "address family and protocol", []byte{b14}, ErrUnsupportedAddressFamilyAndProtocol)
 	}
 
 	// Make sure there are enough bytes available for the address family and protocol
-	var len uint16
-	if err := binary.Read(io.LimitReader(br, 2), binary.BigEndian, &len); err != nil {
-		return nil, ErrCantReadLength
+	var lenBytes [2]byte
+	if n, err := io.ReadFull(br, lenBytes[:]); err != nil {
+		return nil, newV2ParseError(v2LengthOffset, "length", lenBytes[:n], ErrCantReadLength)
 	}
+	len := binary.BigEndian.Uint16(lenBytes[:])
 	if !validateLeastAddressLen(hdr.TransportProtocol, len) {
-		return nil, ErrInvalidLength
+		return nil, newV2ParseError(v2LengthOffset, "length", lenBytes[:], ErrInvalidLength)
 	}
 
-	if _, err := br.Peek(int(len)); err != nil {
-		return nil, ErrInvalidLength
+	if maxHeaderSize > 0 && v2AddressOffset+int(len) > maxHeaderSize {
+		return nil, newV2ParseError(v2LengthOffset, "length", lenBytes[:], ErrHeaderTooLarge)
 	}
 
-	// Length-limited reader for payload section
-	lr := io.LimitReader(br, int64(len))
-
-	// drain the remaining padding
-	defer io.Copy(ioutil.Discard, lr)
+	// Read the whole payload section rather than peeking it as it may be
+	// larger than the buffer of br.
+	payload := make([]byte, len)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, newV2ParseError(v2LengthOffset, "length", lenBytes[:], ErrInvalidLength)
+	}
+	pr := bytes.NewReader(payload)
 
 	// Read addresses and ports
+	var addrLen int
 	switch {
 	case hdr.Command.IsLocal():
 		// The receiver must accept this connection as valid and must use the
@@ -103,55 +118,94 @@ func parseVersion2(br *bufio.Reader) (*Header, error) {
 
 	case hdr.TransportProtocol.IsIPv4():
 		var addr _addr4
-		if err := binary.Read(lr, binary.BigEndian, &addr); err != nil {
-			return nil, ErrInvalidAddress
+		if err := binary.Read(pr, binary.BigEndian, &addr); err != nil {
+			return nil, newV2ParseError(v2AddressOffset, "addresses", nil, ErrInvalidAddress)
 		}
 		hdr.SrcAddr = addr.Src[:]
 		hdr.DstAddr = addr.Dst[:]
 		hdr.SrcPort = addr.SrcPort
 		hdr.DstPort = addr.DstPort
+		addrLen = v4AddrLen
 	case hdr.TransportProtocol.IsIPv6():
 		var addr _addr6
-		if err := binary.Read(lr, binary.BigEndian, &addr); err != nil {
-			return nil, ErrInvalidAddress
+		if err := binary.Read(pr, binary.BigEndian, &addr); err != nil {
+			return nil, newV2ParseError(v2AddressOffset, "addresses", nil, ErrInvalidAddress)
 		}
 		hdr.SrcAddr = addr.Src[:]
 		hdr.DstAddr = addr.Dst[:]
 		hdr.SrcPort = addr.SrcPort
 		hdr.DstPort = addr.DstPort
+		addrLen = v6AddrLen
 	}
 
-	// TODO add encapsulated TLV support
+	// The remaining of the payload section is a series of TLVs
+	hdr.TLVs, err = parseTLVs(payload[addrLen:], v2AddressOffset+addrLen)
+	if err != nil {
+		return nil, err
+	}
 
 	return hdr, nil
 }
 
+func newV2ParseError(offset int, field string, b []byte, err error) *ParseError {
+	return &ParseError{
+		Version: 2,
+		Offset:  offset,
+		Field:   field,
+		Bytes:   append([]byte(nil), b...),
+		Err:     err,
+	}
+}
+
 func (h *Header) writeVersion2(w io.Writer) (int64, error) {
 	buf := &bytes.Buffer{}
 	buf.Write(SIGV2)
 	buf.WriteByte(byte(h.Command))
 	buf.WriteByte(byte(h.TransportProtocol))
 
-	if h.Command.IsLocal() && h.TransportProtocol.IsUnspec() {
-		// terminate with zero length
-		buf.Write(fixedEmptyLen[:])
-		return buf.WriteTo(w)
+	tlvs, err := writeTLVs(h.TLVs)
+	if err != nil {
+		return 0, err
+	}
+
+	// UNSPEC carries no address
+	var addrLen int
+	switch {
+	case h.TransportProtocol.IsIPv4():
+		addrLen = v4AddrLen
+	case h.TransportProtocol.IsIPv6():
+		addrLen = v6AddrLen
+	}
+	pad, err := h.padding(v2AddressOffset + addrLen + len(tlvs))
+	if err != nil {
+		return 0, err
+	}
+	if pad > 0 {
+		noop := writeUint16ByBE(uint16(pad - tlvHeaderLen))
+		tlvs = append(tlvs, byte(PP2_TYPE_NOOP), noop[0], noop[1])
+		tlvs = append(tlvs, make([]byte, pad-tlvHeaderLen)...)
+	}
+
+	if addrLen+len(tlvs) > maxV2Len {
+		return 0, ErrInvalidLength
 	}
+	length := writeUint16ByBE(uint16(addrLen + len(tlvs)))
+	buf.Write(length[:])
 
-	// TODO add encapsulated TLV length
 	switch {
 	case h.TransportProtocol.IsIPv4():
-		buf.Write(fixedV4AddrLen[:])
 		buf.Write(h.SrcAddr.To4())
 		buf.Write(h.DstAddr.To4())
 	case h.TransportProtocol.IsIPv6():
-		buf.Write(fixedV6AddrLen[:])
 		buf.Write(h.SrcAddr.To16())
 		buf.Write(h.DstAddr.To16())
 	}
+	if addrLen > 0 {
+		binary.Write(buf, binary.BigEndian, h.SrcPort)
+		binary.Write(buf, binary.BigEndian, h.DstPort)
+	}
 
-	binary.Write(buf, binary.BigEndian, h.SrcPort)
-	binary.Write(buf, binary.BigEndian, h.DstPort)
+	buf.Write(tlvs)
 	return buf.WriteTo(w)
 }
 
@@ -160,3 +214,33 @@ func writeUint16ByBE(i uint16) [2]byte {
 	binary.BigEndian.PutUint16(b[:], i)
 	return b
 }
+
+// padding returns the size of the NOOP TLV padding a header of n bytes
+// according to PadSize and PadAlign. As a NOOP TLV takes at least 3 bytes,
+// a header 1 or 2 bytes short of alignment is padded to the next multiple.
+func (h *Header) padding(n int) (int, error) {
+	var pad int
+	switch {
+	case h.PadSize > v2AddressOffset+maxV2Len:
+		return 0, fmt.Errorf("%w: PadSize %d exceeds the maximum header size", ErrInvalidLength, h.PadSize)
+	case h.PadSize > 0:
+		pad = h.PadSize - n
+		if pad < 0 {
+			return 0, fmt.Errorf("%w: %d bytes to pad to %d bytes", ErrHeaderTooLarge, n, h.PadSize)
+		}
+		if pad > 0 && pad < tlvHeaderLen {
+			return 0, fmt.Errorf("%w: %d bytes can't be padded to %d bytes", ErrInvalidLength, n, h.PadSize)
+		}
+	case h.PadAlign > maxV2Len:
+		return 0, fmt.Errorf("%w: PadAlign %d exceeds the maximum header size", ErrInvalidLength, h.PadAlign)
+	case h.PadAlign > 0:
+		pad = (h.PadAlign - n%h.PadAlign) % h.PadAlign
+		for pad > 0 && pad < tlvHeaderLen {
+			pad += h.PadAlign
+		}
+	}
+	if pad > 0 && n+pad > v2AddressOffset+maxV2Len {
+		return 0, fmt.Errorf("%w: %d bytes can't be padded by %d bytes", ErrInvalidLength, n, pad)
+	}
+	return pad, nil
+}
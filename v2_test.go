@@ -3,6 +3,7 @@ package proxyproto
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"testing"
 )
 
@@ -94,7 +95,7 @@ func TestReadV2Invalid(t *testing.T) {
 		},
 	} {
 		t.Run("", func(t *testing.T) {
-			if _, err := Read(newBufioReader(tt.bytes)); err != tt.expectedError {
+			if _, err := Read(newBufioReader(tt.bytes)); !errors.Is(err, tt.expectedError) {
 				t.Fatalf("expected %s, actual %s", tt.expectedError, err)
 			}
 		})
@@ -141,6 +142,34 @@ func TestReadWriteV2Valid_Local(t *testing.T) {
 	}
 }
 
+func TestWriteV2Unspec(t *testing.T) {
+	// PROXY with UNSPEC carries no address but keeps its TLVs
+	hdr := &Header{
+		Version:           2,
+		Command:           PROXY,
+		TransportProtocol: UNSPEC,
+		SrcAddr:           v4addr,
+		SrcPort:           PORT,
+		TLVs:              []TLV{{Type: PP2_TYPE_ALPN, Value: []byte("h2")}},
+	}
+	buf := &bytes.Buffer{}
+	if _, err := hdr.WriteTo(buf); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expected := catBytes(SIGV2, proxyBytes, unspecBytes, []byte{0, 5, byte(PP2_TYPE_ALPN), 0, 2}, []byte("h2"))
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("expected % x, actual % x", expected, buf.Bytes())
+	}
+
+	actual, err := Read(newBufioReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if actual.TransportProtocol != UNSPEC || actual.SrcAddr != nil || actual.SrcPort != 0 || !assertTLVs(actual.TLVs, hdr.TLVs) {
+		t.Errorf("expected UNSPEC with %v, actual %#v", hdr.TLVs, actual)
+	}
+}
+
 func TestReadWriteV2Valid(t *testing.T) {
 	for _, tt := range []struct {
 		bytes          []byte
@@ -338,5 +367,55 @@ func assertHeader(actual, expected *Header) bool {
 		actual.SrcAddr.String() == expected.SrcAddr.String() &&
 		actual.DstAddr.String() == expected.DstAddr.String() &&
 		actual.SrcPort == expected.SrcPort &&
-		actual.DstPort == expected.DstPort
+		actual.DstPort == expected.DstPort &&
+		assertTLVs(actual.TLVs, expected.TLVs)
+}
+
+// assertTLVs returns true if the given two TLVs are equivalent.
+func assertTLVs(actual, expected []TLV) bool {
+	if len(actual) != len(expected) {
+		return false
+	}
+	for i := range actual {
+		if actual[i].Type != expected[i].Type || !bytes.Equal(actual[i].Value, expected[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func FuzzParseVersion2(f *testing.F) {
+	for _, b := range [][]byte{
+		SIGV2,
+		catBytes(SIGV2, invalidBytes),
+		catBytes(SIGV2, proxyBytes, invalidBytes),
+		catBytes(SIGV2, proxyBytes, tcpv4Bytes, invalidBytes),
+		catBytes(SIGV2, proxyBytes, tcpv4Bytes, fixedEmptyLen[:], fixtureIPv6Address),
+		catBytes(SIGV2, localBytes, unspecBytes, fixtureIPv4V2),
+		catBytes(SIGV2, proxyBytes, tcpv4Bytes, fixtureIPv4V2),
+		catBytes(SIGV2, proxyBytes, tcpv6Bytes, fixtureIPv6V2),
+		catBytes(SIGV2, proxyBytes, udpv4Bytes, fixtureIPv4V2Padded),
+		catBytes(SIGV2, proxyBytes, udpv6Bytes, fixtureIPv6V2Padded),
+	} {
+		f.Add(b)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		hdr, err := parseVersion2(newBufioReader(b), 0)
+		if err != nil {
+			var perr *ParseError
+			if !errors.As(err, &perr) || perr.Version != 2 {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+			return
+		}
+		if hdr == nil {
+			// LOCAL
+			return
+		}
+		if hdr.Version != 2 {
+			t.Fatalf("unexpected version: %d", hdr.Version)
+		}
+		assertRoundTrip(t, hdr)
+	})
 }